@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// checkpoint records how far a paginated dump to a file has progressed.
+type checkpoint struct {
+	// Primary key of the last dumped row, formatted as text
+	After []string `json:"after,omitempty"`
+
+	// Size of the output file after the statements for that row
+	Bytes int64 `json:"bytes"`
+
+	// Whether the dump completed
+	Done bool `json:"done,omitempty"`
+}
+
+// readCheckpoint reads checkpoint from path. It returns nil if path doesn't
+// exist.
+func readCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("parse checkpoint %s: %w", path, err)
+	}
+
+	return &cp, nil
+}
+
+// writeCheckpoint replaces the checkpoint at path with cp. The file is
+// replaced atomically so a crash leaves either the old or new checkpoint.
+func writeCheckpoint(path string, cp *checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, append(data, '\n'), 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// checkpointKey formats key values as accepted by -page-after.
+func checkpointKey(after []interface{}) []string {
+	key := make([]string, len(after))
+	for i, v := range after {
+		switch v := v.(type) {
+		case time.Time:
+			key[i] = v.Format(time.RFC3339Nano)
+		case []byte:
+			key[i] = string(v)
+		default:
+			key[i] = fmt.Sprint(v)
+		}
+	}
+	return key
+}
@@ -75,27 +75,25 @@ func (s *synchronizer) sync(lastSync time.Time, t tableConfig) {
 func (s *synchronizer) syncUpsert(lastSync time.Time, t tableConfig) {
 	minId := s.partionByAge(lastSync, t)
 	margin := time.Duration(float64(time.Second) * s.c.ClockSynchronizationMarginSeconds)
-	updatedAt := lastSync.Add(-1 * margin).Format(time.RFC3339)
+	updatedAt := lastSync.Add(-1 * margin)
 	s.dump(t, &pgdump.Options{
 		ConflictColumn: t.IdColumn,
-		Query: fmt.Sprintf(
-			"WHERE %s >= %d AND %s >= TIMESTAMP '%s'",
-			t.IdColumn, minId, t.UpdatedAtColumn, updatedAt),
-		Verbose: s.verbose,
+		Query:          fmt.Sprintf("WHERE %s >= $1 AND %s >= $2", t.IdColumn, t.UpdatedAtColumn),
+		QueryArgs:      []interface{}{minId, updatedAt},
+		Verbose:        s.verbose,
 	})
 }
 
 func (s *synchronizer) syncInsert(lastSync time.Time, t tableConfig) {
 	minId := s.partionByAge(lastSync, t)
 	margin := time.Duration(float64(time.Second) * s.c.ClockSynchronizationMarginSeconds)
-	updatedAt := lastSync.Add(-1 * margin).Format(time.RFC3339)
+	updatedAt := lastSync.Add(-1 * margin)
 
 	s.dump(t, &pgdump.Options{
 		NoConflict: true,
-		Query: fmt.Sprintf(
-			`WHERE %s >= %d AND %s >= TIMESTAMP '%s'`,
-			t.IdColumn, minId, t.UpdatedAtColumn, updatedAt),
-		Verbose: s.verbose,
+		Query:      fmt.Sprintf("WHERE %s >= $1 AND %s >= $2", t.IdColumn, t.UpdatedAtColumn),
+		QueryArgs:  []interface{}{minId, updatedAt},
+		Verbose:    s.verbose,
 	})
 }
 
@@ -111,21 +109,37 @@ func (s *synchronizer) syncInsertSerial(lastSync time.Time, t tableConfig) {
 	}
 
 	s.dump(t, &pgdump.Options{
-		Query:   fmt.Sprintf("WHERE %s >= %d", t.IdColumn, minId),
-		Verbose: s.verbose,
+		Query:     fmt.Sprintf("WHERE %s >= $1", t.IdColumn),
+		QueryArgs: []interface{}{minId},
+		Verbose:   s.verbose,
 	})
 }
 
 func (s *synchronizer) execOnFollower(st string) error {
-	s.updated += 1
 	_, err := s.follower.Exec(st)
 	return err
 }
 
 func (s *synchronizer) dump(t tableConfig, pgdumpOpts *pgdump.Options) {
-	if err := pgdump.Dump(s.execOnFollower, pgdump.NewQuerier(s.leaderTx), t.Name, pgdumpOpts); err != nil {
+	if s.verbose {
+		pgdumpOpts.ProgressInterval = 10 * time.Second
+		pgdumpOpts.ProgressFunc = func(p pgdump.Progress) {
+			if !p.Done {
+				log.Printf("... %d rows so far (%.0f rows/s)\n", p.Rows, p.Rate())
+			}
+		}
+	}
+
+	stats, err := pgdump.DumpStats(s.execOnFollower, pgdump.NewQuerier(s.leaderTx), t.Name, pgdumpOpts)
+	s.updated += uint64(stats.Rows)
+
+	if err != nil {
 		log.Panicf("Failed to dump table %s: %v\n", t.Name, err)
 	}
+
+	if s.verbose {
+		log.Printf("Spent %s of %s querying leader.\n", stats.QueryDuration, stats.TotalDuration)
+	}
 }
 
 func (s *synchronizer) partionByAge(lastSync time.Time, t tableConfig) int64 {
@@ -134,11 +148,11 @@ func (s *synchronizer) partionByAge(lastSync time.Time, t tableConfig) int64 {
 	}
 
 	age := time.Duration(float64(time.Second) * t.MaxRecordAgeSeconds)
-	creationTimestamp := lastSync.Add(-1 * age).Format(time.RFC3339)
+	creationTimestamp := lastSync.Add(-1 * age)
 	st := fmt.Sprintf(
-		"SELECT %s FROM %s WHERE %s < TIMESTAMP '%s' ORDER BY 1 DESC LIMIT 1",
-		t.IdColumn, t.Name, t.CreatedAtColumn, creationTimestamp)
-	row := s.leaderTx.QueryRow(st)
+		"SELECT %s FROM %s WHERE %s < $1 ORDER BY 1 DESC LIMIT 1",
+		t.IdColumn, t.Name, t.CreatedAtColumn)
+	row := s.leaderTx.QueryRow(st, creationTimestamp)
 
 	var id int64
 	if err := row.Scan(&id); err == sql.ErrNoRows {
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tomyl/pg-dump-upsert/pgdump"
+)
+
+// config is the contents of a -config file.
+type config struct {
+	Jobs []jobConfig `json:"jobs"`
+}
+
+// jobConfig describes one table to dump, with fields corresponding to the
+// command line flags of the same name.
+type jobConfig struct {
+	From           string   `json:"from"`
+	To             string   `json:"to"`
+	Query          string   `json:"query"`
+	Args           []string `json:"args"`
+	InsertColumns  []string `json:"insert_columns"`
+	UpdateColumns  []string `json:"update_columns"`
+	ConflictColumn string   `json:"conflict_column"`
+	NoConflict     bool     `json:"noconflict"`
+	Format         string   `json:"format"`
+
+	// File to write to, stdout if empty. Jobs with the same output are
+	// written to the same file in order.
+	Output string `json:"output"`
+}
+
+// readConfig parses the -config file at path.
+func readConfig(path string) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+
+	var c config
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+
+	for i, job := range c.Jobs {
+		if job.From == "" {
+			// As with -from omitted, the query selects the rows
+			if job.Query == "" || strings.HasPrefix(strings.ToUpper(strings.TrimSpace(job.Query)), "WHERE") {
+				return nil, fmt.Errorf("config %s: job %d has neither a from table nor a SELECT query", path, i)
+			}
+			if job.To == "" {
+				return nil, fmt.Errorf("config %s: job %d needs a to table when from is omitted", path, i)
+			}
+		}
+		if job.Format == "ndjson" || job.Format == "csv" {
+			return nil, fmt.Errorf("config %s: job %d has format %s, only SQL formats are supported in config files", path, i, job.Format)
+		}
+		if job.NoConflict && job.ConflictColumn != "" {
+			return nil, fmt.Errorf("config %s: job %d combines noconflict and conflict_column", path, i)
+		}
+	}
+
+	return &c, nil
+}
+
+// options returns base with the settings of job applied.
+func (job *jobConfig) options(base *pgdump.Options, mergeRows int) (*pgdump.Options, error) {
+	opts := *base
+
+	opts.InsertTable = job.To
+	opts.Query = job.Query
+	opts.QueryArgs = nil
+	for _, arg := range job.Args {
+		opts.QueryArgs = append(opts.QueryArgs, arg)
+	}
+	opts.InsertColumns = job.InsertColumns
+	opts.UpdateColumns = job.UpdateColumns
+	opts.ConflictColumn = job.ConflictColumn
+	opts.NoConflict = job.NoConflict
+
+	var err error
+	opts.StatementWriter, err = statementWriter(job.Format, job.ConflictColumn, job.NoConflict, job.UpdateColumns, mergeRows)
+	if err != nil {
+		return nil, err
+	}
+
+	return &opts, nil
+}
+
+// runConfig dumps the jobs in the -config file at path in order, within one
+// snapshot on a single connection. Other options in base apply to every job.
+// Each output is wrapped in a transaction if tx is set.
+func runConfig(db *sql.DB, path string, base *pgdump.Options, mergeRows int, tx bool, level int) error {
+	c, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	snapshotTx, err := pgdump.BeginSnapshot(context.Background(), db, base.Snapshot)
+	if err != nil {
+		return err
+	}
+	defer snapshotTx.Rollback()
+
+	q := pgdump.NewQuerier(snapshotTx)
+
+	outputs := make(map[string]*output)
+	var order []*output
+
+	// Flush compressed output also on errors
+	defer func() {
+		for _, out := range order {
+			out.Close()
+		}
+	}()
+
+	for i, job := range c.Jobs {
+		opts, err := job.options(base, mergeRows)
+		if err != nil {
+			return fmt.Errorf("job %d: %w", i, err)
+		}
+
+		out := outputs[job.Output]
+		if out == nil {
+			if out, err = openOutput(job.Output, level); err != nil {
+				return fmt.Errorf("job %d: %w", i, err)
+			}
+
+			outputs[job.Output] = out
+			order = append(order, out)
+
+			if tx {
+				if _, err := io.WriteString(out, "BEGIN;\n"); err != nil {
+					return err
+				}
+			}
+		}
+
+		dumpFunc := func(st string) error {
+			_, err := io.WriteString(out, st)
+			return err
+		}
+
+		table := job.From
+		if table == "" {
+			table = job.To
+		}
+
+		if err := pgdump.Dump(dumpFunc, q, job.From, opts); err != nil {
+			return fmt.Errorf("job %d: dump %s: %w", i, table, err)
+		}
+	}
+
+	for _, out := range order {
+		if tx {
+			if _, err := io.WriteString(out, "COMMIT;\n"); err != nil {
+				return err
+			}
+		}
+
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,6 @@
 module github.com/tomyl/pg-dump-upsert
 
-go 1.14
+go 1.23.0
 
 require (
 	github.com/georgysavva/scany v0.2.9
@@ -9,3 +9,11 @@ require (
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/net v0.38.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.2.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
@@ -2,6 +2,8 @@ package pgdump
 
 import (
 	"database/sql"
+	"encoding/hex"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -10,7 +12,8 @@ import (
 	"github.com/lib/pq"
 )
 
-type column struct {
+// Column describes a column being dumped.
+type Column struct {
 	// Name of column
 	Name string
 
@@ -23,12 +26,27 @@ type column struct {
 	// Whether column is an array
 	Array bool
 
+	// Qualified and quoted name of the column type e.g. "pg_catalog"."int4",
+	// usable in casts. Array types are named by their array type e.g.
+	// "pg_catalog"."_int4".
+	TypeName string
+}
+
+type column struct {
+	Column
+
+	// Data type including modifiers e.g. "character varying(20)"
+	sqlType string
+
+	// Default expression, if any
+	defaultValue sql.NullString
+
+	// Identity generation, ALWAYS or BY DEFAULT, if identity column
+	identity sql.NullString
+
 	// Whether to include this column in INSERT statement
 	insert bool
 
-	// Whether to include this column in DO UPDATE SET list
-	update bool
-
 	// Scan destination for this column, see getScanDest().
 	value interface{}
 }
@@ -74,7 +92,7 @@ func (col *column) bind() {
 		}
 	case "boolean":
 		if col.Array {
-			col.value = new([]pq.BoolArray)
+			col.value = new(pq.BoolArray)
 		} else if col.Nullable {
 			col.value = new(sql.NullBool)
 		} else {
@@ -90,6 +108,13 @@ func (col *column) bind() {
 		} else {
 			col.value = new(uuid.UUID)
 		}
+	case "bytea":
+		if col.Array {
+			col.value = new(pq.ByteaArray)
+		} else {
+			// NULL is scanned as nil
+			col.value = new([]byte)
+		}
 	default:
 		if col.Array {
 			panic("don't know how to bind array column " + col.Name + " of type " + col.Type)
@@ -229,6 +254,20 @@ func (col column) literal() string {
 		}
 
 		return pq.QuoteLiteral(result.String())
+	case "bytea":
+		if col.Array {
+			vs := *col.value.(*pq.ByteaArray)
+			if len(vs) == 0 {
+				return "'{}'"
+			}
+			literals := make([]string, len(vs))
+			for i, x := range vs {
+				literals[i] = byteaLiteral(x)
+			}
+			return "ARRAY[" + strings.Join(literals, ", ") + "]"
+		}
+
+		return byteaLiteral(*col.value.(*[]byte))
 	default:
 		if col.Array {
 			panic("don't know how to quote array column " + col.Name + " of type " + col.Type)
@@ -237,15 +276,106 @@ func (col column) literal() string {
 	}
 }
 
+// byteaLiteral quotes b as a bytea literal in hex format.
+func byteaLiteral(b []byte) string {
+	if b == nil {
+		return "NULL"
+	}
+	return pq.QuoteLiteral(`\x`+hex.EncodeToString(b)) + "::bytea"
+}
+
+// goValue returns the scanned value as a plain Go value. NULL is returned as
+// nil, arrays as slices.
+func (col column) goValue() interface{} {
+	if col.value == nil {
+		panic("column " + col.Name + " of type " + col.Type + " is not bound")
+	}
+
+	switch v := col.value.(type) {
+	case *pq.Int64Array:
+		if *v != nil {
+			return []int64(*v)
+		}
+	case *sql.NullInt64:
+		if v.Valid {
+			return v.Int64
+		}
+	case *int64:
+		return *v
+	case *pq.Float64Array:
+		if *v != nil {
+			return []float64(*v)
+		}
+	case *sql.NullFloat64:
+		if v.Valid {
+			return v.Float64
+		}
+	case *float64:
+		return *v
+	case *pq.StringArray:
+		if *v != nil {
+			return []string(*v)
+		}
+	case *sql.NullString:
+		if v.Valid {
+			return v.String
+		}
+	case *string:
+		return *v
+	case *pq.NullTime:
+		if v.Valid {
+			return v.Time
+		}
+	case *time.Time:
+		return *v
+	case *pq.BoolArray:
+		if *v != nil {
+			return []bool(*v)
+		}
+	case *sql.NullBool:
+		if v.Valid {
+			return v.Bool
+		}
+	case *bool:
+		return *v
+	case *uuid.NullUUID:
+		if v.Valid {
+			return v.UUID
+		}
+	case *uuid.UUID:
+		return *v
+	case *pq.ByteaArray:
+		if *v != nil {
+			return [][]byte(*v)
+		}
+	case *[]byte:
+		if *v != nil {
+			return *v
+		}
+	default:
+		panic("don't know how to convert column " + col.Name + " of type " + col.Type)
+	}
+
+	return nil
+}
+
 // getColumns fetches column list for table from database.
 func getColumns(q Querier, table string, opts *Options) ([]column, error) {
 	rows, err := q.Query(`
-		SELECT c.column_name, c.data_type, e.data_type as element_data_type, c.is_nullable
+		SELECT c.column_name, c.data_type, e.data_type as element_data_type, c.is_nullable, c.udt_schema, c.udt_name,
+			format_type(a.atttypid, a.atttypmod), c.column_default, c.identity_generation
 		FROM information_schema.columns c
 		LEFT OUTER JOIN information_schema.element_types e
 			ON (c.table_catalog, c.table_schema, c.table_name, 'TABLE', c.dtd_identifier)
 				= (e.object_catalog, e.object_schema, e.object_name, e.object_type, e.collection_type_identifier)
-		WHERE table_name = $1 AND c.is_generated = 'NEVER'
+		JOIN pg_attribute a ON a.attrelid = $1::regclass AND a.attname = c.column_name
+		WHERE (c.table_schema::text, c.table_name::text) = (
+			SELECT n.nspname::text, t.relname::text
+			FROM pg_class t
+			JOIN pg_namespace n ON n.oid = t.relnamespace
+			WHERE t.oid = $1::regclass
+		) AND c.is_generated = 'NEVER'
+		ORDER BY c.ordinal_position
 	`, table)
 	if err != nil {
 		return nil, err
@@ -259,11 +389,15 @@ func getColumns(q Querier, table string, opts *Options) ([]column, error) {
 		var col column
 		var elementDataType sql.NullString
 		var nullable string
+		var udtSchema, udtName string
 
-		if err := rows.Scan(&col.Name, &col.Type, &elementDataType, &nullable); err != nil {
+		if err := rows.Scan(&col.Name, &col.Type, &elementDataType, &nullable, &udtSchema, &udtName,
+			&col.sqlType, &col.defaultValue, &col.identity); err != nil {
 			return nil, err
 		}
 
+		col.TypeName = pq.QuoteIdentifier(udtSchema) + "." + pq.QuoteIdentifier(udtName)
+
 		if col.Type == "ARRAY" {
 			col.Array = true
 			if elementDataType.String == "USER-DEFINED" {
@@ -279,7 +413,6 @@ func getColumns(q Querier, table string, opts *Options) ([]column, error) {
 			col.Nullable = true
 		}
 		if shouldFetchColumn(col.Name, opts) {
-			col.bind()
 			cols = append(cols, col)
 		}
 	}
@@ -287,6 +420,121 @@ func getColumns(q Querier, table string, opts *Options) ([]column, error) {
 	return cols, rows.Err()
 }
 
+// resultTypes maps type names reported by the driver to the data type names
+// used by information_schema.
+var resultTypes = map[string]string{
+	"INT2":        "smallint",
+	"INT4":        "integer",
+	"INT8":        "bigint",
+	"FLOAT4":      "real",
+	"FLOAT8":      "double precision",
+	"NUMERIC":     "numeric",
+	"MONEY":       "money",
+	"VARCHAR":     "character varying",
+	"BPCHAR":      "character",
+	"TEXT":        "text",
+	"NAME":        "text",
+	"JSON":        "json",
+	"JSONB":       "jsonb",
+	"TSVECTOR":    "tsvector",
+	"TIMESTAMP":   "timestamp without time zone",
+	"TIMESTAMPTZ": "timestamp with time zone",
+	"DATE":        "date",
+	"TIME":        "time without time zone",
+	"TIMETZ":      "time with time zone",
+	"BOOL":        "boolean",
+	"UUID":        "uuid",
+	"BYTEA":       "bytea",
+}
+
+// getResultColumns derives column list from the result set of a query. Every
+// result column must exist in destCols, the columns of the destination table.
+// Types the driver can't name (e.g. enums) are taken from destCols if the
+// destination column has a user-defined type too. Other types must be known.
+func getResultColumns(rows *sql.Rows, destCols []column, destTable string) ([]column, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	cols := make([]column, 0, len(types))
+
+	for _, ct := range types {
+		var dest *column
+		for i := range destCols {
+			if destCols[i].Name == ct.Name() {
+				dest = &destCols[i]
+				break
+			}
+		}
+		if dest == nil {
+			return nil, fmt.Errorf("result column %s does not exist in table %s", ct.Name(), destTable)
+		}
+
+		for _, col := range cols {
+			if col.Name == ct.Name() {
+				return nil, fmt.Errorf("duplicate result column %s", ct.Name())
+			}
+		}
+
+		var col column
+		col.Name = ct.Name()
+		// Result columns don't carry nullability, assume the worst
+		col.Nullable = true
+
+		typeName := ct.DatabaseTypeName()
+
+		if typeName == "" {
+			// Not a built-in type, only the destination column can tell
+			if !dest.userDefined() {
+				return nil, fmt.Errorf("result column %s has a user-defined type but column in table %s has type %s",
+					col.Name, destTable, dest.sqlType)
+			}
+
+			col.Type = dest.Type
+			col.TypeName = dest.TypeName
+			col.Array = dest.Array
+
+			cols = append(cols, col)
+			continue
+		}
+
+		if strings.HasPrefix(typeName, "_") {
+			col.Array = true
+			typeName = typeName[1:]
+		}
+
+		if col.Array != dest.Array {
+			return nil, fmt.Errorf("result column %s is %s but column in table %s is %s",
+				col.Name, describeArray(col.Array), destTable, describeArray(dest.Array))
+		}
+
+		t, ok := resultTypes[typeName]
+		if !ok {
+			return nil, fmt.Errorf("result column %s has unsupported type %s", col.Name, strings.ToLower(ct.DatabaseTypeName()))
+		}
+
+		col.Type = t
+		col.TypeName = pq.QuoteIdentifier("pg_catalog") + "." + pq.QuoteIdentifier(strings.ToLower(ct.DatabaseTypeName()))
+
+		cols = append(cols, col)
+	}
+
+	return cols, nil
+}
+
+// userDefined reports whether the type of col is not a built-in type.
+func (col *column) userDefined() bool {
+	return !strings.HasPrefix(col.TypeName, pq.QuoteIdentifier("pg_catalog")+".")
+}
+
+func describeArray(array bool) string {
+	if array {
+		return "an array"
+	}
+	return "not an array"
+}
+
 func shouldFetchColumn(name string, opts *Options) bool {
 	if len(opts.InsertColumns) == 0 {
 		// No columns specified, fetch all!
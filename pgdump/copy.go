@@ -0,0 +1,224 @@
+package pgdump
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultCopyBatchSize is the default number of statements per transaction
+// executed by Copy.
+const DefaultCopyBatchSize = 1000
+
+// CopyOptions controls how Copy executes statements in the target database.
+type CopyOptions struct {
+	// Max number of statements per transaction. Defaults to
+	// DefaultCopyBatchSize.
+	BatchSize int
+
+	// Column identifying rows in errors. Defaults to ConflictColumn, or the
+	// first dumped column. For statements covering several rows, such as
+	// MERGE, the key of the last row is reported.
+	KeyColumn string
+
+	// Continue with the next batch when a batch fails instead of stopping.
+	ContinueOnError bool
+}
+
+// BatchError describes a batch of statements that failed and was rolled back.
+type BatchError struct {
+	// Number of batch, starting at 0
+	Batch int
+
+	// Failing statement and the key of its row as SQL literal. Key is empty
+	// for statements not belonging to a row, e.g. setval.
+	Statement string
+	Key       string
+
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("batch %d failed at row %s: %v", e.Batch, e.Key, e.Err)
+	}
+	return fmt.Sprintf("batch %d failed: %v", e.Batch, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// batchExecutor executes statements in transactions of up to size statements.
+type batchExecutor struct {
+	ctx  context.Context
+	db   *sql.DB
+	size int
+
+	// Continue after failed batches, collecting errors in errs
+	keepGoing bool
+	errs      []error
+
+	// Statements of current batch and their keys
+	stmts []string
+	keys  []string
+
+	// Number of current batch
+	batch int
+}
+
+// add appends st to the current batch, executing the batch when full.
+func (e *batchExecutor) add(st, key string) error {
+	e.stmts = append(e.stmts, st)
+	e.keys = append(e.keys, key)
+
+	if len(e.stmts) >= e.size {
+		return e.flush()
+	}
+
+	return nil
+}
+
+// flush executes the current batch in one round trip. If it fails, the
+// statements are executed one by one in a new transaction to find the one
+// failing.
+func (e *batchExecutor) flush() error {
+	if len(e.stmts) == 0 {
+		return nil
+	}
+
+	err := e.exec(strings.Join(e.stmts, ""))
+
+	if err != nil {
+		batchErr := e.locate()
+		if batchErr.Err == nil {
+			// Failed only when executed together
+			batchErr.Err = err
+		}
+		err = batchErr
+	}
+
+	e.stmts = e.stmts[:0]
+	e.keys = e.keys[:0]
+	e.batch++
+
+	if err != nil && e.keepGoing {
+		e.errs = append(e.errs, err)
+		return nil
+	}
+
+	return err
+}
+
+// exec executes st in a transaction.
+func (e *batchExecutor) exec(st string) error {
+	tx, err := e.db.BeginTx(e.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(e.ctx, st); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// locate finds the failing statement of the current batch.
+func (e *batchExecutor) locate() *BatchError {
+	batchErr := &BatchError{Batch: e.batch}
+
+	tx, err := e.db.BeginTx(e.ctx, nil)
+	if err != nil {
+		batchErr.Err = err
+		return batchErr
+	}
+	defer tx.Rollback()
+
+	for i, st := range e.stmts {
+		if _, err := tx.ExecContext(e.ctx, st); err != nil {
+			batchErr.Statement = st
+			batchErr.Key = e.keys[i]
+			batchErr.Err = err
+			break
+		}
+	}
+
+	return batchErr
+}
+
+// close executes the last batch and returns the errors of all failed batches.
+func (e *batchExecutor) close() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+	return errors.Join(e.errs...)
+}
+
+// Copy executes the statements Dump would output for sourceTable directly in
+// the target database, in transactions of BatchSize statements. A failed
+// batch is rolled back and returned as a *BatchError identifying the failing
+// row. With ContinueOnError, all batches are executed and the errors of failed
+// batches are joined, see errors.As.
+func Copy(target *sql.DB, q Querier, sourceTable string, opts *Options, copyOpts *CopyOptions) (*Stats, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if copyOpts == nil {
+		copyOpts = &CopyOptions{}
+	}
+
+	stats := &Stats{NullCounts: make(map[string]int64)}
+
+	if opts.DeferConstraints {
+		return stats, fmt.Errorf("cannot defer constraints when copying in batches")
+	}
+
+	e := &batchExecutor{
+		ctx:       context.Background(),
+		db:        target,
+		size:      copyOpts.BatchSize,
+		keepGoing: copyOpts.ContinueOnError,
+	}
+
+	if e.size <= 0 {
+		e.size = DefaultCopyBatchSize
+	}
+
+	keyColumn := copyOpts.KeyColumn
+	if keyColumn == "" {
+		keyColumn = opts.ConflictColumn
+	}
+
+	keyIndex := -1
+
+	execFunc := func(st string) error {
+		var key string
+
+		if stats.row != nil {
+			if keyIndex < 0 {
+				if keyColumn == "" {
+					keyIndex = 0
+				} else if i, err := findKey(keyColumn, stats.cols); err != nil {
+					return fmt.Errorf("key column: %w", err)
+				} else {
+					keyIndex = i
+				}
+			}
+			key = stats.row[keyIndex]
+		}
+
+		return e.add(st, key)
+	}
+
+	if err := dump(execFunc, execFunc, q, sourceTable, opts, stats); err != nil {
+		return stats, err
+	}
+
+	return stats, e.close()
+}
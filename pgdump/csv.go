@@ -0,0 +1,224 @@
+package pgdump
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVOptions controls the format of DumpCSV output.
+type CSVOptions struct {
+	// Output column names as the first line.
+	Header bool
+
+	// Field delimiter, an ASCII character. Defaults to comma.
+	Delimiter rune
+
+	// Quote character, an ASCII character. Quotes within quoted fields are
+	// doubled. Defaults to double quote.
+	Quote rune
+
+	// Representation of NULL, unquoted. Defaults to the empty string, in which
+	// case empty strings are quoted.
+	Null string
+}
+
+// DumpCSV writes the rows Query would return for sourceTable to writer as CSV,
+// in the format read by PostgreSQL's COPY ... FROM with FORMAT csv and the
+// same delimiter, quote and NULL settings. Values are written in their
+// PostgreSQL text representation. Fields are quoted if they contain the
+// delimiter, the quote character or a line break, or equal the NULL
+// representation.
+func DumpCSV(writer io.Writer, q Querier, sourceTable string, opts *Options, csvOpts *CSVOptions) error {
+	if csvOpts == nil {
+		csvOpts = &CSVOptions{}
+	}
+
+	w := csvWriter{
+		delimiter: ",",
+		quote:     `"`,
+		null:      csvOpts.Null,
+	}
+
+	if csvOpts.Delimiter != 0 {
+		w.delimiter = string(csvOpts.Delimiter)
+	}
+
+	if csvOpts.Quote != 0 {
+		w.quote = string(csvOpts.Quote)
+	}
+
+	if err := w.validate(); err != nil {
+		return err
+	}
+
+	rows, err := Query(q, sourceTable, opts)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var cols []column
+	var names []string
+
+	for _, col := range rows.cols {
+		if col.insert {
+			cols = append(cols, col)
+			names = append(names, col.Name)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if csvOpts.Header {
+		for i, name := range names {
+			w.writeField(&buf, i, name, true)
+		}
+		buf.WriteString("\n")
+
+		if _, err := writer.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	for rows.Next() {
+		buf.Reset()
+
+		for i, col := range cols {
+			s, valid := col.text()
+			w.writeField(&buf, i, s, valid)
+		}
+		buf.WriteString("\n")
+
+		if _, err := writer.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// csvWriter formats CSV fields.
+type csvWriter struct {
+	delimiter string
+	quote     string
+	null      string
+}
+
+// validate returns an error if the settings make fields ambiguous.
+func (w csvWriter) validate() error {
+	if w.delimiter == w.quote {
+		return fmt.Errorf("CSV delimiter and quote must differ")
+	}
+
+	for _, s := range []string{w.delimiter, w.quote} {
+		// COPY only accepts single-byte characters
+		if len(s) != 1 {
+			return fmt.Errorf("CSV delimiter and quote must be ASCII characters")
+		}
+		if s == "\r" || s == "\n" {
+			return fmt.Errorf("CSV delimiter and quote cannot be a line break")
+		}
+	}
+
+	if strings.Contains(w.null, w.delimiter) || strings.Contains(w.null, w.quote) || strings.ContainsAny(w.null, "\r\n") {
+		return fmt.Errorf("CSV NULL representation cannot contain the delimiter, quote or a line break")
+	}
+
+	return nil
+}
+
+// writeField writes field i of a line to buf, or NULL if not valid.
+func (w csvWriter) writeField(buf *bytes.Buffer, i int, s string, valid bool) {
+	if i > 0 {
+		buf.WriteString(w.delimiter)
+	}
+
+	if !valid {
+		buf.WriteString(w.null)
+		return
+	}
+
+	// COPY would read \. on its own as end of data
+	if s == w.null || s == `\.` || strings.Contains(s, w.delimiter) || strings.Contains(s, w.quote) || strings.ContainsAny(s, "\r\n") {
+		buf.WriteString(w.quote + strings.ReplaceAll(s, w.quote, w.quote+w.quote) + w.quote)
+		return
+	}
+
+	buf.WriteString(s)
+}
+
+// text returns the scanned value in PostgreSQL text representation, and false
+// for NULL.
+func (col column) text() (string, bool) {
+	v := col.goValue()
+
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return formatFloat(v), true
+	case []float64:
+		// pq.Float64Array would write infinities as +Inf
+		elems := make([]string, len(v))
+		for i, f := range v {
+			elems[i] = formatFloat(f)
+		}
+		return "{" + strings.Join(elems, ",") + "}", true
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case time.Time:
+		switch col.Type {
+		case "date":
+			return v.Format("2006-01-02"), true
+		case "time without time zone":
+			return v.Format("15:04:05.999999"), true
+		case "time with time zone":
+			return v.Format("15:04:05.999999-07:00"), true
+		case "timestamp without time zone":
+			return v.Format("2006-01-02 15:04:05.999999"), true
+		}
+		return v.Format("2006-01-02 15:04:05.999999-07:00"), true
+	case []byte:
+		return `\x` + hex.EncodeToString(v), true
+	case fmt.Stringer:
+		// uuid.UUID
+		return v.String(), true
+	}
+
+	// Arrays
+	if valuer, ok := col.value.(driver.Valuer); ok {
+		if value, err := valuer.Value(); err == nil {
+			switch value := value.(type) {
+			case string:
+				return value, true
+			case []byte:
+				return string(value), true
+			}
+		}
+	}
+
+	panic("don't know how to format column " + col.Name + " of type " + col.Type)
+}
+
+// formatFloat returns f in PostgreSQL text representation.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
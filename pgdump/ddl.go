@@ -0,0 +1,104 @@
+package pgdump
+
+import (
+	"bytes"
+	"strings"
+)
+
+// serialTypes maps integer types to the serial type creating an owned
+// sequence.
+var serialTypes = map[string]string{
+	"smallint": "smallserial",
+	"integer":  "serial",
+	"bigint":   "bigserial",
+}
+
+// getPrimaryKey fetches the primary key columns of table. Returns nil if table
+// has no primary key.
+func getPrimaryKey(q Querier, table string) ([]string, error) {
+	rows, err := q.Query(`
+		SELECT a.attname
+		FROM pg_index i
+		JOIN unnest(i.indkey::int2[]) WITH ORDINALITY AS k(attnum, n) ON true
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+		WHERE i.indrelid = $1::regclass AND i.indisprimary
+		ORDER BY k.n
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var key []string
+
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		key = append(key, col)
+	}
+
+	return key, rows.Err()
+}
+
+// getCreateTableStatement returns CREATE TABLE statement for destTable with
+// the definition of the columns to dump from table.
+func getCreateTableStatement(q Querier, table, destTable string, opts *Options) (string, error) {
+	cols, err := getColumns(q, table, opts)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := getPrimaryKey(q, table)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	var defs []string
+	included := make(map[string]bool)
+
+	for _, col := range cols {
+		buf.Reset()
+		buf.WriteString(quoteColumn(col.Name) + " ")
+
+		if serial, ok := serialTypes[col.sqlType]; ok && strings.HasPrefix(col.defaultValue.String, "nextval(") {
+			// The sequence is created along with the table
+			buf.WriteString(serial)
+		} else {
+			buf.WriteString(col.sqlType)
+			if col.identity.Valid {
+				buf.WriteString(" GENERATED " + col.identity.String + " AS IDENTITY")
+			} else if col.defaultValue.Valid {
+				buf.WriteString(" DEFAULT " + col.defaultValue.String)
+			}
+		}
+
+		if !col.Nullable {
+			buf.WriteString(" NOT NULL")
+		}
+
+		defs = append(defs, buf.String())
+		included[col.Name] = true
+	}
+
+	hasKey := len(key) > 0
+	for _, col := range key {
+		if !included[col] {
+			hasKey = false
+		}
+	}
+
+	if hasKey {
+		defs = append(defs, "PRIMARY KEY ("+quoteColumns(key)+")")
+	}
+
+	if opts.ConflictColumn != "" && !(hasKey && len(key) == 1 && key[0] == opts.ConflictColumn) {
+		// ON CONFLICT requires a unique index
+		defs = append(defs, "UNIQUE ("+quoteColumn(opts.ConflictColumn)+")")
+	}
+
+	return "CREATE TABLE IF NOT EXISTS " + destTable + " (\n\t" + strings.Join(defs, ",\n\t") + "\n);\n", nil
+}
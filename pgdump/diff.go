@@ -0,0 +1,262 @@
+package pgdump
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// DiffOptions controls DumpDiff.
+type DiffOptions struct {
+	// Column identifying rows in both tables. Defaults to ConflictColumn.
+	KeyColumn string
+
+	// Output DELETE statements for rows only in the target table.
+	Deletes bool
+}
+
+// DumpDiff outputs statements making the insert table in the target database
+// identical to the rows Dump would output for sourceTable, but only for rows
+// that differ. Rows are matched by key and compared by hashes of the dumped
+// columns computed in each database, so the columns must have the same types
+// on both ends. Timestamps, floating point numbers, money and bytea are hashed
+// in a form independent of session settings such as TimeZone, DateStyle,
+// extra_float_digits and bytea_output. Keys are matched by their text
+// representation, so the key column should be an integer, text or uuid.
+// Query, if any, must be a WHERE clause and is applied to both tables.
+//
+// Missing rows are output with the configured StatementWriter, INSERT by
+// default, and changed rows as UPDATE statements setting UpdateColumns, or
+// all columns except the key. With Deletes set in diffOpts, rows only in the
+// target table are deleted last.
+func DumpDiff(dumpFunc func(string) error, q, target Querier, sourceTable string, opts *Options, diffOpts *DiffOptions) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if diffOpts == nil {
+		diffOpts = &DiffOptions{}
+	}
+
+	if sourceTable == "" {
+		return fmt.Errorf("diff requires a source table")
+	}
+
+	if opts.MirrorColumn != "" {
+		return fmt.Errorf("cannot combine diff and mirror mode")
+	}
+
+	key := diffOpts.KeyColumn
+	if key == "" {
+		key = opts.ConflictColumn
+	}
+
+	if key == "" {
+		return fmt.Errorf("diff requires a key column")
+	}
+
+	destTable := opts.InsertTable
+	if destTable == "" {
+		destTable = sourceTable
+	}
+
+	cols, err := getColumns(q, sourceTable, opts)
+	if err != nil {
+		return fmt.Errorf("get columns: %w", err)
+	}
+
+	if err := validateColumns(cols, opts); err != nil {
+		return fmt.Errorf("validate columns: %w", err)
+	}
+
+	var hashCols []column
+	var keyCol *column
+
+	for i := range cols {
+		if cols[i].insert {
+			hashCols = append(hashCols, cols[i])
+			if cols[i].Name == key {
+				keyCol = &cols[i]
+			}
+		}
+	}
+
+	if keyCol == nil {
+		return fmt.Errorf("key column %s not in dumped columns", key)
+	}
+
+	targetHashes, err := getHashes(target, destTable, key, hashCols, opts)
+	if err != nil {
+		return fmt.Errorf("hash target rows: %w", err)
+	}
+
+	sourceHashes, err := getHashes(q, sourceTable, key, hashCols, opts)
+	if err != nil {
+		return fmt.Errorf("hash source rows: %w", err)
+	}
+
+	var inserted, updated []string
+
+	for k, h := range sourceHashes {
+		if th, ok := targetHashes[k]; !ok {
+			inserted = append(inserted, k)
+		} else if th != h {
+			updated = append(updated, k)
+		}
+		delete(targetHashes, k)
+	}
+
+	var deleted []string
+	if diffOpts.Deletes {
+		for k := range targetHashes {
+			deleted = append(deleted, k)
+		}
+	}
+
+	sort.Strings(inserted)
+	sort.Strings(updated)
+	sort.Strings(deleted)
+
+	getLogger(opts).Info("Diff", "table", sourceTable, "inserted", len(inserted), "updated", len(updated), "deleted", len(deleted))
+
+	if len(inserted) > 0 {
+		insertOpts, err := getKeyOptions(keyCol.Column, inserted, opts)
+		if err != nil {
+			return err
+		}
+
+		if err := Dump(dumpFunc, q, sourceTable, insertOpts); err != nil {
+			return fmt.Errorf("dump inserted rows: %w", err)
+		}
+	}
+
+	if len(updated) > 0 {
+		updateOpts, err := getKeyOptions(keyCol.Column, updated, opts)
+		if err != nil {
+			return err
+		}
+
+		updateOpts.StatementWriter = UpdateWriter{KeyColumn: key, UpdateColumns: opts.UpdateColumns}
+		updateOpts.CreateTable = false
+		updateOpts.Sequences = NoSequences
+
+		if err := Dump(dumpFunc, q, sourceTable, updateOpts); err != nil {
+			return fmt.Errorf("dump updated rows: %w", err)
+		}
+	}
+
+	for i := 0; i < len(deleted); i += mirrorInListSize {
+		end := i + mirrorInListSize
+		if end > len(deleted) {
+			end = len(deleted)
+		}
+
+		literals := make([]string, end-i)
+		for j, k := range deleted[i:end] {
+			literals[j] = pq.QuoteLiteral(k)
+		}
+
+		if err := dumpFunc("DELETE FROM " + destTable + " WHERE " + quoteColumn(key) + " IN (" + strings.Join(literals, ", ") + ");\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getHashes returns the md5 hash of cols of each row in table matching the
+// filter in opts, by the text representation of key.
+func getHashes(q Querier, table, key string, cols []column, opts *Options) (map[string]string, error) {
+	filter, err := getFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := make([]string, len(cols))
+	for i, col := range cols {
+		exprs[i] = hashExpression(col)
+	}
+
+	st := "SELECT " + quoteColumn(key) + "::text, md5(ROW(" + strings.Join(exprs, ", ") + ")::text) FROM " + table
+	if filter != "" {
+		st += " WHERE " + filter
+	}
+
+	rows, err := q.Query(st, opts.QueryArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	hashes := make(map[string]string)
+
+	for rows.Next() {
+		var k, h string
+		if err := rows.Scan(&k, &h); err != nil {
+			return nil, err
+		}
+		hashes[k] = h
+	}
+
+	return hashes, rows.Err()
+}
+
+// hashExpression returns an expression for col whose text representation
+// doesn't depend on session settings.
+func hashExpression(col column) string {
+	name := quoteColumn(col.Name)
+
+	elem, ok := normalizeExpression(col.Type, "x")
+	if !ok {
+		return name
+	}
+
+	if !col.Array {
+		elem, _ = normalizeExpression(col.Type, name)
+		return elem
+	}
+
+	return "ROW(array_dims(" + name + "), (SELECT array_agg(" + elem + " ORDER BY i) FROM unnest(" + name + ") WITH ORDINALITY AS u(x, i)))"
+}
+
+// normalizeExpression returns an expression converting expr of type typ to a
+// type whose text representation doesn't depend on session settings, and
+// false if that is already the case.
+func normalizeExpression(typ, expr string) (string, bool) {
+	switch typ {
+	case "timestamp without time zone", "timestamp with time zone", "date", "time without time zone", "time with time zone":
+		return "extract(epoch FROM " + expr + ")", true
+	case "real":
+		return "float4send(" + expr + ")", true
+	case "double precision":
+		return "float8send(" + expr + ")", true
+	case "money":
+		return expr + "::numeric", true
+	case "bytea":
+		return "encode(" + expr + ", 'hex')", true
+	}
+	return expr, false
+}
+
+// getKeyOptions returns opts restricted to rows whose key is in keys, the
+// text representations of key values.
+func getKeyOptions(key Column, keys []string, opts *Options) (*Options, error) {
+	filter, err := getFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	keyOpts := *opts
+	keyOpts.QueryArgs = append(append([]interface{}{}, opts.QueryArgs...), pq.StringArray(keys))
+	keyOpts.Query = "WHERE " + quoteColumn(key.Name) + " = ANY($" + strconv.Itoa(len(keyOpts.QueryArgs)) + "::" + key.TypeName + "[])"
+
+	if filter != "" {
+		keyOpts.Query += " AND (" + filter + ")"
+	}
+
+	return &keyOpts, nil
+}
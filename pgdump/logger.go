@@ -0,0 +1,58 @@
+package pgdump
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives diagnostics, see Options.Logger. Arguments after msg are
+// alternating keys and values. The method set matches *slog.Logger, so one can
+// be used directly.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// getLogger returns the logger to use for opts.
+func getLogger(opts *Options) Logger {
+	if opts.Logger != nil {
+		return opts.Logger
+	}
+	if opts.Verbose {
+		return stdLogger{}
+	}
+	return nopLogger{}
+}
+
+// stdLogger writes all levels to the standard log package.
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, args ...interface{}) { logArgs(msg, args) }
+func (stdLogger) Info(msg string, args ...interface{})  { logArgs(msg, args) }
+func (stdLogger) Warn(msg string, args ...interface{})  { logArgs(msg, args) }
+func (stdLogger) Error(msg string, args ...interface{}) { logArgs(msg, args) }
+
+// logArgs logs msg followed by key=value pairs.
+func logArgs(msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	log.Print(b.String())
+}
+
+// nopLogger discards everything.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
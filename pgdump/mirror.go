@@ -0,0 +1,111 @@
+package pgdump
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// mirrorInListSize is the max number of keys to put in a NOT IN list. Larger
+// key sets are loaded into a temporary table.
+const mirrorInListSize = 1000
+
+// mirrorKeyTable is the name of the temporary table holding dumped keys.
+const mirrorKeyTable = "pgdump_mirror_keys"
+
+// getMirrorStatements returns statements deleting rows from table that match
+// the query filter but whose key column isn't among keys, the literals of the
+// dumped keys.
+func getMirrorStatements(table string, key Column, keys []string, opts *Options) (string, error) {
+	filter, err := getFilter(opts)
+	if err != nil {
+		return "", fmt.Errorf("mirror: %w", err)
+	}
+
+	var buf bytes.Buffer
+	var cond []string
+
+	if filter != "" {
+		cond = append(cond, "("+filter+")")
+	}
+
+	if len(keys) > mirrorInListSize {
+		buf.WriteString("DROP TABLE IF EXISTS " + mirrorKeyTable + ";\n")
+		buf.WriteString("CREATE TEMPORARY TABLE " + mirrorKeyTable + " (key " + key.TypeName + ");\n")
+
+		for i := 0; i < len(keys); i += mirrorInListSize {
+			end := i + mirrorInListSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			buf.WriteString("INSERT INTO " + mirrorKeyTable + " (key) VALUES (" + strings.Join(keys[i:end], "), (") + ");\n")
+		}
+
+		cond = append(cond, quoteColumn(key.Name)+" NOT IN (SELECT key FROM "+mirrorKeyTable+")")
+	} else if len(keys) > 0 {
+		cond = append(cond, quoteColumn(key.Name)+" NOT IN ("+strings.Join(keys, ", ")+")")
+	}
+
+	st := "DELETE FROM " + table
+	if len(cond) > 0 {
+		st += " WHERE " + strings.Join(cond, " AND ")
+	}
+
+	if len(opts.QueryArgs) > 0 {
+		// Bind the filter arguments with a prepared statement
+		literals := make([]string, len(opts.QueryArgs))
+		for i, arg := range opts.QueryArgs {
+			if literals[i], err = argLiteral(arg); err != nil {
+				return "", fmt.Errorf("mirror: argument %d: %w", i+1, err)
+			}
+		}
+		buf.WriteString("PREPARE pgdump_mirror AS " + st + ";\n")
+		buf.WriteString("EXECUTE pgdump_mirror (" + strings.Join(literals, ", ") + ");\n")
+		buf.WriteString("DEALLOCATE pgdump_mirror;\n")
+	} else {
+		buf.WriteString(st + ";\n")
+	}
+
+	if len(keys) > mirrorInListSize {
+		buf.WriteString("DROP TABLE " + mirrorKeyTable + ";\n")
+	}
+
+	return buf.String(), nil
+}
+
+// argLiteral quotes a query argument as an SQL literal. The literal is left
+// untyped so Postgres resolves it to the parameter type.
+func argLiteral(arg interface{}) (string, error) {
+	if valuer, ok := arg.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return "", err
+		}
+		arg = v
+	}
+
+	switch v := arg.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return pq.QuoteLiteral(v), nil
+	case []byte:
+		// Bound as bytea by the driver, so use the hex format
+		if v == nil {
+			return "NULL", nil
+		}
+		return pq.QuoteLiteral(`\x` + hex.EncodeToString(v)), nil
+	case time.Time:
+		return pq.QuoteLiteral(v.Format(time.RFC3339Nano)), nil
+	case float64:
+		return pq.QuoteLiteral(strconv.FormatFloat(v, 'f', -1, 64)), nil
+	default:
+		return pq.QuoteLiteral(fmt.Sprint(v)), nil
+	}
+}
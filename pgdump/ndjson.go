@@ -0,0 +1,146 @@
+package pgdump
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// jsonNumber matches numbers valid in JSON.
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// DumpNDJSON writes the rows Query would return for sourceTable to writer as
+// newline-delimited JSON, one object per row with the dumped columns as keys
+// in order. Numbers and booleans are encoded as such, timestamps in RFC3339
+// format, without offset for timestamp without time zone, json and jsonb as
+// compacted nested JSON, arrays as JSON arrays and bytea as base64 strings.
+// NaN and infinite numbers are encoded as strings.
+func DumpNDJSON(writer io.Writer, q Querier, sourceTable string, opts *Options) error {
+	rows, err := Query(q, sourceTable, opts)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var cols []column
+	var keys [][]byte
+
+	for _, col := range rows.cols {
+		if col.insert {
+			key, err := marshalJSON(col.Name)
+			if err != nil {
+				return err
+			}
+			cols = append(cols, col)
+			keys = append(keys, key)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	for rows.Next() {
+		buf.Reset()
+		buf.WriteByte('{')
+
+		for i, col := range cols {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			value, err := marshalJSON(col.jsonValue())
+			if err != nil {
+				return err
+			}
+
+			buf.Write(keys[i])
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+
+		buf.WriteString("}\n")
+
+		if _, err := writer.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// marshalJSON encodes v without escaping HTML characters.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// jsonValue returns the scanned value to encode as JSON.
+func (col column) jsonValue() interface{} {
+	v := col.goValue()
+
+	switch v := v.(type) {
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, s := range v {
+			values[i] = col.jsonString(s)
+		}
+		return values
+	case string:
+		return col.jsonString(v)
+	case []float64:
+		values := make([]interface{}, len(v))
+		for i, f := range v {
+			values[i] = jsonFloat(f)
+		}
+		return values
+	case float64:
+		return jsonFloat(v)
+	case time.Time:
+		switch col.Type {
+		case "date":
+			return v.Format("2006-01-02")
+		case "time without time zone":
+			return v.Format("15:04:05.999999")
+		case "time with time zone":
+			return v.Format("15:04:05.999999Z07:00")
+		case "timestamp without time zone":
+			return v.Format("2006-01-02T15:04:05.999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+
+	return v
+}
+
+// jsonString returns value s of the column's type to encode as JSON.
+func (col column) jsonString(s string) interface{} {
+	switch col.Type {
+	case "json", "jsonb":
+		return json.RawMessage(s)
+	case "decimal", "numeric":
+		if jsonNumber.MatchString(s) {
+			return json.Number(s)
+		}
+	}
+	return s
+}
+
+// jsonFloat returns f, or f as string if JSON can't represent it.
+func jsonFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return f
+}
@@ -0,0 +1,128 @@
+package pgdump
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pager builds queries fetching rows in pages ordered by primary key.
+type pager struct {
+	// SELECT statement without WHERE clause
+	base string
+
+	// Condition from Query, if any
+	filter string
+
+	// Arguments for filter
+	args []interface{}
+
+	// Primary key columns
+	key []string
+
+	// Index of key columns in fetched columns
+	keyIndex []int
+
+	// Max number of rows per page
+	size int
+
+	// Number of rows fetched from current page
+	rows int
+
+	// Key of last fetched row, nil before first page
+	after []interface{}
+}
+
+// newPager returns pager for sourceTable ordered by its primary key.
+func newPager(q Querier, sourceTable string, cols []column, opts *Options) (*pager, error) {
+	filter, err := getFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := getPrimaryKey(q, sourceTable)
+	if err != nil {
+		return nil, fmt.Errorf("get primary key: %w", err)
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("table %s has no primary key", sourceTable)
+	}
+
+	p := &pager{
+		base:   getQueryStatement(sourceTable, cols),
+		filter: filter,
+		args:   opts.QueryArgs,
+		key:    key,
+		size:   opts.PageSize,
+		after:  opts.PageAfter,
+	}
+
+	for _, name := range key {
+		found := false
+		for i, col := range cols {
+			if col.Name == name && col.insert {
+				p.keyIndex = append(p.keyIndex, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("primary key column %s not in insert columns", name)
+		}
+	}
+
+	if p.after != nil && len(p.after) != len(key) {
+		return nil, fmt.Errorf("got %d values to start after but primary key has %d columns", len(p.after), len(key))
+	}
+
+	return p, nil
+}
+
+// query returns statement and arguments fetching the next page.
+func (p *pager) query() (string, []interface{}) {
+	var conds []string
+	args := append([]interface{}{}, p.args...)
+
+	if p.filter != "" {
+		conds = append(conds, "("+p.filter+")")
+	}
+
+	if p.after != nil {
+		placeholders := make([]string, len(p.after))
+		for i, v := range p.after {
+			args = append(args, v)
+			placeholders[i] = "$" + strconv.Itoa(len(args))
+		}
+		conds = append(conds, "("+quoteColumns(p.key)+") > ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	st := p.base
+	if len(conds) > 0 {
+		st += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	st += " ORDER BY " + quoteColumns(p.key) + " LIMIT " + strconv.Itoa(p.size)
+
+	return st, args
+}
+
+// done reports whether the last page has been fetched.
+func (p *pager) done() bool {
+	return !p.full()
+}
+
+// full reports whether all rows of the current page have been fetched and
+// there may be more pages.
+func (p *pager) full() bool {
+	return p.rows == p.size
+}
+
+// fetched records that row with columns cols was fetched.
+func (p *pager) fetched(cols []column) {
+	p.rows++
+	p.after = make([]interface{}, len(p.keyIndex))
+	for i, index := range p.keyIndex {
+		p.after[i] = cols[index].goValue()
+	}
+}
@@ -0,0 +1,238 @@
+package pgdump
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// keyRange is a range of primary key values, lo inclusive and hi exclusive.
+// The range holding the max key has no upper bound, and ranges after it are
+// empty.
+type keyRange struct {
+	lo, hi int64
+
+	// Whether the range has no upper bound
+	open bool
+
+	// Whether this is the last range
+	last bool
+}
+
+// getKeyRanges splits the values of key in rows matching opts into n ranges.
+func getKeyRanges(q Querier, table, key string, opts *Options, n int) ([]keyRange, error) {
+	filter, err := getFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	st := "SELECT min(" + quoteColumn(key) + "), max(" + quoteColumn(key) + ") FROM " + table
+	if filter != "" {
+		st += " WHERE " + filter
+	}
+
+	var min, max sql.NullInt64
+	if err := q.QueryRow(st, opts.QueryArgs...).Scan(&min, &max); err != nil {
+		return nil, err
+	}
+
+	// Offsets from min are unsigned since the span of the keys may not fit
+	// in an int64
+	span := uint64(max.Int64) - uint64(min.Int64)
+	step := span/uint64(n) + 1
+
+	ranges := make([]keyRange, n)
+	for i := range ranges {
+		if i > 0 && ranges[i-1].open {
+			ranges[i].lo = max.Int64
+			ranges[i].hi = max.Int64
+			continue
+		}
+
+		off := uint64(i) * step
+		ranges[i].lo = int64(uint64(min.Int64) + off)
+
+		if i == n-1 || step > span-off {
+			ranges[i].open = true
+		} else {
+			ranges[i].hi = int64(uint64(min.Int64) + off + step)
+		}
+	}
+	ranges[n-1].last = true
+
+	return ranges, nil
+}
+
+// getRangeOptions returns options selecting rows in r.
+func getRangeOptions(key string, r keyRange, opts *Options) (*Options, error) {
+	filter, err := getFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rangeOpts := *opts
+	rangeOpts.QueryArgs = append(append([]interface{}{}, opts.QueryArgs...), r.lo)
+	rangeOpts.Query = "WHERE " + quoteColumn(key) + " >= $" + strconv.Itoa(len(rangeOpts.QueryArgs))
+
+	if !r.open {
+		rangeOpts.QueryArgs = append(rangeOpts.QueryArgs, r.hi)
+		rangeOpts.Query += " AND " + quoteColumn(key) + " < $" + strconv.Itoa(len(rangeOpts.QueryArgs))
+	}
+
+	if filter != "" {
+		rangeOpts.Query += " AND (" + filter + ")"
+	}
+
+	// Pages are already fetched in key order
+	if opts.PageSize <= 0 {
+		rangeOpts.Query += " ORDER BY " + quoteColumn(key)
+	}
+
+	return &rangeOpts, nil
+}
+
+// DumpParallel outputs statements for all rows in sourceTable like Dump, but
+// splits the table into jobs ranges of its primary key and fetches them
+// concurrently on separate connections from db. The primary key must be a
+// single integer column. All connections see the same snapshot, either
+// Snapshot in opts or one exported from a new transaction.
+//
+// Statements for range i, in key order, are passed to the function returned
+// by rangeFunc(i). The CREATE TABLE statement, if any, is output with the
+// first range and sequence statements with the last. Mirror mode is not
+// supported.
+func DumpParallel(rangeFunc func(int) (func(string) error, error), db *sql.DB, sourceTable string, opts *Options, jobs int) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if jobs < 1 {
+		return fmt.Errorf("invalid number of jobs %d", jobs)
+	}
+
+	if opts.MirrorColumn != "" {
+		return fmt.Errorf("mirror mode is not supported when dumping in parallel")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Export snapshot to share with the jobs unless one was given. The
+	// exporting transaction must stay open until all jobs have imported it.
+	tx, err := BeginSnapshot(ctx, db, opts.Snapshot)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := NewQuerierContext(ctx, tx)
+
+	snapshot := opts.Snapshot
+	if snapshot == "" {
+		if err := q.QueryRow(`SELECT pg_export_snapshot()`).Scan(&snapshot); err != nil {
+			return fmt.Errorf("export snapshot: %w", err)
+		}
+	}
+
+	key, err := getPrimaryKey(q, sourceTable)
+	if err != nil {
+		return fmt.Errorf("get primary key: %w", err)
+	}
+
+	if len(key) != 1 {
+		return fmt.Errorf("table %s must have a single column primary key", sourceTable)
+	}
+
+	cols, err := getColumns(q, sourceTable, &Options{InsertColumns: key})
+	if err != nil {
+		return fmt.Errorf("get columns: %w", err)
+	}
+
+	if len(cols) != 1 || (cols[0].Type != "smallint" && cols[0].Type != "integer" && cols[0].Type != "bigint") {
+		return fmt.Errorf("primary key of table %s must be an integer", sourceTable)
+	}
+
+	ranges, err := getKeyRanges(q, sourceTable, key[0], opts, jobs)
+	if err != nil {
+		return fmt.Errorf("get key ranges: %w", err)
+	}
+
+	logger := getLogger(opts)
+
+	// Prepare all ranges before starting any job, so that nothing is left
+	// running on errors
+	rangeOpts := make([]*Options, len(ranges))
+	dumpFuncs := make([]func(string) error, len(ranges))
+
+	for i := range ranges {
+		if rangeOpts[i], err = getRangeOptions(key[0], ranges[i], opts); err != nil {
+			return err
+		}
+
+		if i > 0 {
+			rangeOpts[i].CreateTable = false
+		}
+
+		if !ranges[i].last {
+			rangeOpts[i].Sequences = NoSequences
+		}
+
+		if dumpFuncs[i], err = rangeFunc(i); err != nil {
+			return err
+		}
+	}
+
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+
+	for i := range ranges {
+		logger.Info("Dumping range", "table", sourceTable, "range", i, "from", ranges[i].lo)
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			if err := dumpRange(ctx, dumpFuncs[i], db, snapshot, sourceTable, rangeOpts[i]); err != nil {
+				errs[i] = fmt.Errorf("range %d: %w", i, err)
+				if !errors.Is(err, context.Canceled) {
+					logger.Error("Range failed", "table", sourceTable, "range", i, "error", err)
+				}
+				cancel()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	// Prefer the error that caused other jobs to be canceled
+	var canceled error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		if canceled == nil {
+			canceled = err
+		}
+	}
+
+	return canceled
+}
+
+// dumpRange dumps rows in a transaction using snapshot.
+func dumpRange(ctx context.Context, dumpFunc func(string) error, db *sql.DB, snapshot, sourceTable string, opts *Options) error {
+	tx, err := BeginSnapshot(ctx, db, snapshot)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return Dump(dumpFunc, NewQuerierContext(ctx, tx), sourceTable, opts)
+}
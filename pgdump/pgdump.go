@@ -2,10 +2,11 @@
 package pgdump
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
-	"log"
 	"strings"
+	"time"
 )
 
 // Options type controls how INSERT statements are constructed.
@@ -15,9 +16,30 @@ type Options struct {
 	InsertTable string
 
 	// Custom SELECT query. Defaults to fetch all rows. Can also just specify
-	// the WHERE clause, it wil be appended to the default query.
+	// the WHERE clause, it wil be appended to the default query. When no
+	// source table is passed to Dump, this must be a complete SELECT and
+	// column names and types are taken from its result set.
 	Query string
 
+	// Arguments for bind parameters $1..$n referenced by Query.
+	QueryArgs []interface{}
+
+	// Fetch rows in pages of this many rows ordered by primary key, using a
+	// separate query for each page. Pass a Querier for a REPEATABLE READ
+	// transaction to have all pages see the same snapshot. Query must be
+	// empty or a WHERE clause and the primary key columns must be included
+	// in InsertColumns.
+	PageSize int
+
+	// Primary key of the row to start after when fetching in pages. Use to
+	// resume an interrupted dump.
+	PageAfter []interface{}
+
+	// Called when fetching in pages after the statements for each page have
+	// been passed to dumpFunc, with the primary key of the last row in the
+	// page. Pass it as PageAfter to resume after the page.
+	PageFunc func(after []interface{}) error
+
 	// Which columns to include in INSERT statement. Defaults to all columns if empty.
 	InsertColumns []string
 
@@ -25,84 +47,342 @@ type Options struct {
 	// up in a DO UPDATE SET list.
 	ConflictColumn string
 
+	// Columns to include in the DO UPDATE SET list instead of all columns
+	// except ConflictColumn. Each must be a dumped column. With a
+	// StatementWriter, they must be set on the writer instead.
+	UpdateColumns []string
+
 	// Append ON CONFLICT DO NOTHING clause.
 	NoConflict bool
 
-	// Log query statement to stderr.
+	// Append a DELETE statement removing rows from the insert table that
+	// match Query but weren't dumped, identifying rows by this column. Query
+	// must be empty or a WHERE clause.
+	MirrorColumn string
+
+	// Output CREATE TABLE IF NOT EXISTS statement for the insert table
+	// before the INSERT statements.
+	CreateTable bool
+
+	// Append statements updating sequences owned by dumped columns.
+	Sequences SequenceMode
+
+	// Custom statement shape. Defaults to INSERT statements according to
+	// ConflictColumn and NoConflict.
+	StatementWriter StatementWriter
+
+	// When dumping several tables with cyclic foreign keys, output SET
+	// CONSTRAINTS ALL DEFERRED instead of failing. The foreign keys in the
+	// cycle must be deferrable and the output must be restored in a
+	// transaction.
+	DeferConstraints bool
+
+	// Snapshot id exported by pg_export_snapshot() to import in the
+	// transactions opened by DumpParallel. Other functions query the
+	// Querier they are given, see BeginSnapshot.
+	Snapshot string
+
+	// Compress output of DumpStream with gzip.
+	Gzip bool
+
+	// Compression level for Gzip, see compress/gzip. Zero selects
+	// gzip.DefaultCompression.
+	GzipLevel int
+
+	// Called periodically while dumping and once when all rows of a table
+	// have been dumped. Called concurrently by DumpParallel.
+	ProgressFunc func(Progress)
+
+	// How often to call ProgressFunc. Defaults to DefaultProgressInterval.
+	ProgressInterval time.Duration
+
+	// Count the rows to dump with count(*) for Progress.TotalRows instead of
+	// using the planner's estimate, which is only available without a filter.
+	CountRows bool
+
+	// Column to report the largest dumped value of in Stats.MaxKey. Defaults
+	// to ConflictColumn.
+	MaxKeyColumn string
+
+	// Log query statement and row counts to stderr with the standard log
+	// package. Ignored if Logger is set.
 	Verbose bool
+
+	// Receives diagnostics: queries at debug level, tables and row counts at
+	// info level and failures of parallel jobs at error level.
+	Logger Logger
 }
 
+// DumpStream is like Dump but writes statements to writer. If Gzip is set in
+// opts, the output is gzip-compressed. The compressed stream is flushed and
+// terminated also when dumping fails, so the rows written so far can be read.
 func DumpStream(writer io.Writer, q Querier, table string, opts *Options) error {
-	dumpFunc := func(st string) error {
-		_, err := writer.Write([]byte(st))
+	if opts == nil || !opts.Gzip {
+		return Dump(writeFunc(writer), q, table, opts)
+	}
+
+	level := opts.GzipLevel
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	gz, err := gzip.NewWriterLevel(writer, level)
+	if err != nil {
 		return err
 	}
 
-	return Dump(dumpFunc, q, table, opts)
+	err = Dump(writeFunc(gz), q, table, opts)
+
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// writeFunc returns a dump function writing statements to writer.
+func writeFunc(writer io.Writer) func(string) error {
+	return func(st string) error {
+		_, err := io.WriteString(writer, st)
+		return err
+	}
 }
 
-// Dump outputs INSERT statements for all rows in specified table.
+// Dump outputs INSERT statements for all rows in specified table. Rows are
+// fetched with Query, see there for how sourceTable and opts are interpreted.
 func Dump(dumpFunc func(string) error, q Querier, sourceTable string, opts *Options) error {
 	if opts == nil {
 		opts = &Options{}
 	}
 
-	// Ask database for column list for this table
-	cols, err := getColumns(q, sourceTable, opts)
+	_, err := DumpStats(dumpFunc, q, sourceTable, opts)
+	return err
+}
+
+// dump implements Dump. Statements deleting rows in mirror mode are passed to
+// mirrorFunc. Statistics are collected in stats.
+func dump(dumpFunc, mirrorFunc func(string) error, q Querier, sourceTable string, opts *Options, stats *Stats) error {
+	start := time.Now()
+	defer func() {
+		stats.TotalDuration = time.Since(start)
+	}()
+
+	dumpFunc = stats.wrap(dumpFunc)
+	mirrorFunc = stats.wrap(mirrorFunc)
+
+	var prog *progress
+
+	if opts.ProgressFunc != nil {
+		table := sourceTable
+		if table == "" {
+			table = opts.InsertTable
+		}
+
+		var err error
+		if prog, err = newProgress(q, sourceTable, table, opts, stats); err != nil {
+			return fmt.Errorf("progress: %w", err)
+		}
+	}
+
+	if opts.CreateTable {
+		destTable := opts.InsertTable
+		if destTable == "" {
+			destTable = sourceTable
+		}
+
+		table := sourceTable
+		if table == "" {
+			// Use definition of destination table when dumping a query
+			table = destTable
+		}
+
+		st, err := getCreateTableStatement(q, table, destTable, opts)
+		if err != nil {
+			return fmt.Errorf("create table: %w", err)
+		} else if err := dumpFunc(st); err != nil {
+			return err
+		}
+	}
+
+	queryStart := time.Now()
+	rows, err := Query(q, sourceTable, opts)
+	stats.QueryDuration += time.Since(queryStart)
 
 	if err != nil {
-		return fmt.Errorf("get columns: %w", err)
+		return err
 	}
 
-	// Verify insert/conflict columns exist
-	if err := validateColumns(cols, opts); err != nil {
-		return fmt.Errorf("validate columns: %w", err)
+	defer rows.Close()
+
+	writer := getStatementWriter(opts)
+	batchWriter, _ := writer.(BatchStatementWriter)
+	cols := rows.Columns()
+
+	maxKey := -1
+
+	if opts.MaxKeyColumn != "" {
+		if maxKey, err = findKey(opts.MaxKeyColumn, cols); err != nil {
+			return fmt.Errorf("max key: %w", err)
+		}
+	} else if opts.ConflictColumn != "" {
+		// Not an error if the conflict column isn't dumped
+		maxKey, _ = findKey(opts.ConflictColumn, cols)
 	}
 
-	// Query rows to dump
-	st := opts.Query
+	var batch [][]string
+	var keys []string
+	mirrorKey := -1
 
-	if st == "" {
-		st = getQueryStatement(sourceTable, cols)
-	} else if strings.HasPrefix(strings.ToUpper(st), "WHERE") {
-		where := st
-		st = getQueryStatement(sourceTable, cols) + " " + where
+	if opts.MirrorColumn != "" {
+		if _, err := getFilter(opts); err != nil {
+			return fmt.Errorf("mirror: %w", err)
+		}
+		if mirrorKey, err = findKey(opts.MirrorColumn, cols); err != nil {
+			return fmt.Errorf("mirror: %w", err)
+		}
 	}
 
-	if opts.Verbose {
-		log.Println(st)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		st, err := batchWriter.WriteBatchStatement(rows.table, cols, batch)
+		batch = batch[:0]
+		if err != nil {
+			return err
+		}
+		return dumpFunc(st)
 	}
 
-	rows, err := q.Query(st)
+	// Output batched rows of the current page before reporting it
+	endPage := func() error {
+		if err := flush(); err != nil {
+			return err
+		}
+		return opts.PageFunc(rows.pager.after)
+	}
 
-	if err != nil {
-		return fmt.Errorf("query rows: %w", err)
+	for {
+		fetchStart := time.Now()
+		next := rows.Next()
+		stats.QueryDuration += time.Since(fetchStart)
+
+		if !next {
+			break
+		}
+
+		literals := rows.literals()
+		stats.fetched(cols, literals)
+
+		if maxKey >= 0 {
+			stats.key(cols[maxKey], rows.Values()[maxKey])
+		}
+
+		if mirrorKey >= 0 && literals[mirrorKey] != "NULL" {
+			keys = append(keys, literals[mirrorKey])
+		}
+
+		if batchWriter != nil {
+			batch = append(batch, literals)
+			if len(batch) >= batchWriter.BatchSize() {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		} else {
+			st, err := writer.WriteStatement(rows.table, cols, literals)
+			if err != nil {
+				return err
+			} else if err := dumpFunc(st); err != nil {
+				return err
+			}
+		}
+
+		if opts.PageFunc != nil && rows.pager != nil && rows.pager.full() {
+			if err := endPage(); err != nil {
+				return err
+			}
+		}
+
+		if prog != nil {
+			prog.row()
+		}
 	}
 
-	defer rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	destTable := opts.InsertTable
-	if destTable == "" {
-		destTable = sourceTable
+	// Last page is partial
+	if opts.PageFunc != nil && rows.pager != nil && rows.pager.rows > 0 && !rows.pager.full() {
+		if err := endPage(); err != nil {
+			return err
+		}
 	}
 
-	dest := getScanDest(cols)
-	count := 0
+	if err := flush(); err != nil {
+		return err
+	}
+
+	// Following statements don't belong to a row
+	stats.row = nil
+
+	if opts.Sequences != NoSequences {
+		table := sourceTable
+		if table == "" {
+			table = rows.table
+		}
 
-	for rows.Next() {
-		if err := rows.Scan(dest...); err != nil {
+		// Free the connection before querying sequences
+		rows.Close()
+
+		st, err := getSequenceStatements(q, table, rows.table, cols, opts.Sequences)
+		if err != nil {
+			return fmt.Errorf("sequences: %w", err)
+		}
+
+		if st != "" {
+			if err := dumpFunc(st); err != nil {
+				return err
+			}
+		}
+	}
+
+	if mirrorKey >= 0 {
+		st, err := getMirrorStatements(rows.table, cols[mirrorKey], keys, opts)
+		if err != nil {
 			return err
-		} else if err := dumpFunc(getInsertStatement(destTable, cols, opts)); err != nil {
+		} else if err := mirrorFunc(st); err != nil {
 			return err
 		}
-		count++
 	}
 
-	if opts.Verbose {
-		log.Printf("Fetched %d rows", count)
+	if prog != nil {
+		prog.done()
 	}
 
-	return rows.Err()
+	getLogger(opts).Info("Fetched rows", "table", rows.table, "rows", rows.count)
+
+	return nil
+}
+
+// isWhereClause reports whether query is just a WHERE clause to append to the
+// default query.
+func isWhereClause(query string) bool {
+	return strings.HasPrefix(strings.ToUpper(query), "WHERE")
+}
+
+// getFilter returns the condition of the WHERE clause in Query, if any.
+func getFilter(opts *Options) (string, error) {
+	if opts.Query == "" {
+		return "", nil
+	}
+
+	if !isWhereClause(opts.Query) {
+		return "", fmt.Errorf("query must be a WHERE clause")
+	}
+
+	return strings.TrimSpace(opts.Query[len("WHERE"):]), nil
 }
 
 func validateColumns(cols []column, opts *Options) error {
@@ -135,9 +415,6 @@ func validateColumns(cols []column, opts *Options) error {
 		for i := range cols {
 			if cols[i].Name == opts.ConflictColumn {
 				foundconflictcol = true
-			} else if cols[i].insert {
-				// Add column to DO UPDATE SET list
-				cols[i].update = true
 			}
 		}
 		if !foundconflictcol {
@@ -145,6 +422,39 @@ func validateColumns(cols []column, opts *Options) error {
 		}
 	}
 
+	// Verify update columns are dumped and used, before any row is fetched
+	update := opts.UpdateColumns
+	if opts.StatementWriter != nil {
+		var writerUpdate []string
+		switch w := opts.StatementWriter.(type) {
+		case UpsertWriter:
+			writerUpdate = w.UpdateColumns
+		case UpdateWriter:
+			writerUpdate = w.UpdateColumns
+		case MergeWriter:
+			writerUpdate = w.UpdateColumns
+		}
+
+		if len(update) > 0 && len(writerUpdate) == 0 {
+			return fmt.Errorf("update columns are not used by %T, set them on the statement writer", opts.StatementWriter)
+		}
+
+		update = append(append([]string{}, update...), writerUpdate...)
+	}
+
+	for _, colname := range update {
+		found := false
+		for i := range cols {
+			if cols[i].Name == colname && cols[i].insert {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown update column %s", colname)
+		}
+	}
+
 	return nil
 }
 
@@ -1,8 +1,18 @@
 package pgdump_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/georgysavva/scany/sqlscan"
 	"github.com/stretchr/testify/require"
@@ -140,3 +150,1279 @@ func TestDumpView(t *testing.T) {
 	require.Equal(t, 1, len(ids))
 	require.Equal(t, 2, ids[0])
 }
+
+func TestDumpQueryArgs(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	// Prepare dest table
+	_, err = tempdb.Exec(strings.ReplaceAll(schemaWithoutGeneratedColumn, "mytable", "mytable2"))
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertTable = "mytable2"
+	opts.Query = "WHERE myinteger >= $1 AND mytext <> $2"
+	opts.QueryArgs = []interface{}{100000, "O'Brien"}
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+
+	// Insert dumped rows
+	_, err = tempdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	// Make sure we got the expected dump
+	var ids []int
+	require.NoError(t, sqlscan.Select(context.Background(), tempdb, &ids, `select myid from mytable2`))
+	require.Equal(t, []int{1}, ids)
+}
+
+func TestDumpResultColumns(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	// Prepare dest table
+	_, err = tempdb.Exec(`create table summary (myid integer primary key, label text not null, total bigint, doubled integer[])`)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertTable = "summary"
+	opts.Query = `
+		with t as (select * from mytable)
+		select t.myid, upper(t.mytext) || '!' as label, sum(t.myinteger) over () as total, array[t.myid, t.myid * 2] as doubled
+		from t`
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "", &opts))
+
+	// Insert dumped rows
+	_, err = tempdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	// Make sure we got the expected dump
+	var labels []string
+	require.NoError(t, sqlscan.Select(context.Background(), tempdb, &labels, `select label from summary where total = 213456 order by myid`))
+	require.Equal(t, []string{"ALICE!", "BOB!"}, labels)
+
+	// Result columns must exist in destination table
+	opts.Query = `select myid, mytext from mytable`
+	err = pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "", &opts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "result column mytext does not exist in table summary")
+
+	// Result types must be supported
+	opts.Query = `select myid, interval '1 day' as label from mytable`
+	err = pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "", &opts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "result column label has unsupported type interval")
+}
+
+func TestQueryValues(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertColumns = []string{"myid", "mytext", "myfloat", "camelcase1"}
+	opts.Query = "WHERE myid = 2"
+
+	rows, err := pgdump.Query(pgdump.NewQuerier(tempdb), "mytable", &opts)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var names []string
+	for _, col := range rows.Columns() {
+		names = append(names, col.Name)
+	}
+	require.ElementsMatch(t, opts.InsertColumns, names)
+
+	require.True(t, rows.Next())
+	values := make(map[string]interface{})
+	for i, v := range rows.Values() {
+		values[names[i]] = v
+	}
+	require.Equal(t, int64(2), values["myid"])
+	require.Equal(t, "Bob", values["mytext"])
+	require.Equal(t, float64(-1), values["myfloat"])
+	require.Nil(t, values["camelcase1"])
+
+	require.False(t, rows.Next())
+	require.NoError(t, rows.Err())
+}
+
+func TestDumpStatementWriter(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	// Prepare dest table with stale rows
+	_, err = tempdb.Exec(strings.ReplaceAll(schemaWithoutGeneratedColumn, "mytable", "mytable2"))
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(strings.ReplaceAll(strings.ReplaceAll(rows, "mytable", "mytable2"), "Bob", "Robert"))
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertTable = "mytable2"
+	opts.InsertColumns = []string{"myid", "mytext"}
+	opts.StatementWriter = pgdump.UpdateWriter{KeyColumn: "myid"}
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+
+	_, err = tempdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	var names []string
+	require.NoError(t, sqlscan.Select(context.Background(), tempdb, &names, `select mytext from mytable2 order by myid`))
+	require.Equal(t, []string{"Alice", "Bob"}, names)
+
+	// Delete rows present in source table
+	opts.Query = "WHERE myid = 1"
+	opts.StatementWriter = pgdump.DeleteWriter{KeyColumn: "myid"}
+
+	dump.Reset()
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+
+	_, err = tempdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	var ids []int
+	require.NoError(t, sqlscan.Select(context.Background(), tempdb, &ids, `select myid from mytable2`))
+	require.Equal(t, []int{2}, ids)
+}
+
+func TestDumpMerge(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	var version int
+	require.NoError(t, tempdb.QueryRow(`show server_version_num`).Scan(&version))
+	if version < 150000 {
+		t.Skip("MERGE requires PostgreSQL 15")
+	}
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	// Prepare dest table with one stale row
+	_, err = tempdb.Exec(strings.ReplaceAll(schemaWithoutGeneratedColumn, "mytable", "mytable2"))
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(`insert into mytable2 (myid, mytext, myinteger, myfloat) values (2, 'Robert', 1, 1)`)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertTable = "mytable2"
+	opts.ConflictColumn = "myid"
+	opts.StatementWriter = pgdump.MergeWriter{KeyColumn: "myid", Rows: 1}
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+	require.Equal(t, 2, strings.Count(dump.String(), "MERGE INTO"))
+
+	_, err = tempdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	// Make sure the tables are identical
+	var ids []int
+	require.NoError(t, sqlscan.Select(context.Background(), tempdb, &ids, `select myid from (select * from mytable except select * from mytable2) ss`))
+	require.Equal(t, 0, len(ids))
+
+	// Only update columns are set in matching rows
+	dump.Reset()
+	opts.StatementWriter = pgdump.MergeWriter{KeyColumn: "myid", UpdateColumns: []string{"myinteger"}}
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+	require.Contains(t, dump.String(), `WHEN MATCHED THEN UPDATE SET "myinteger"=s."myinteger" WHEN NOT MATCHED`)
+
+	// Update columns in options must be set on the writer
+	opts.StatementWriter = pgdump.MergeWriter{KeyColumn: "myid"}
+	opts.UpdateColumns = []string{"myinteger"}
+	err = pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "update columns are not used by pgdump.MergeWriter")
+}
+
+func TestDumpMirror(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	// Prepare dest table with rows that were deleted in source table
+	_, err = tempdb.Exec(strings.ReplaceAll(schemaWithoutGeneratedColumn, "mytable", "mytable2"))
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(`insert into mytable2 (myid, mytext, myinteger, myfloat) values (3, 'Carol', 1, 1), (4, 'Dave', 200000, 1), (5, 'Eve', 300000, 1)`)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertTable = "mytable2"
+	opts.ConflictColumn = "myid"
+	opts.MirrorColumn = "myid"
+	opts.Query = "WHERE myinteger >= $1"
+	opts.QueryArgs = []interface{}{100000}
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+
+	_, err = tempdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	// Rows outside the filter are kept
+	var ids []int
+	require.NoError(t, sqlscan.Select(context.Background(), tempdb, &ids, `select myid from mytable2 order by myid`))
+	require.Equal(t, []int{1, 3}, ids)
+
+	// Binary arguments are bound as bytea
+	for _, table := range []string{"mybytes", "mybytes2"} {
+		_, err = tempdb.Exec(`create table ` + table + ` (myid integer primary key, mybytea bytea not null)`)
+		require.NoError(t, err)
+	}
+
+	_, err = tempdb.Exec(`insert into mybytes values (1, '\x00ff'), (2, '\x01')`)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(`insert into mybytes2 values (1, '\x00ff'), (3, '\x00ff'), (4, '\x01')`)
+	require.NoError(t, err)
+
+	opts = pgdump.Options{
+		InsertTable:    "mybytes2",
+		ConflictColumn: "myid",
+		MirrorColumn:   "myid",
+		Query:          "WHERE mybytea = $1",
+		QueryArgs:      []interface{}{[]byte{0x00, 0xff}},
+	}
+
+	dump.Reset()
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mybytes", &opts))
+
+	_, err = tempdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	var byteIDs []int
+	require.NoError(t, sqlscan.Select(context.Background(), tempdb, &byteIDs, `select myid from mybytes2 order by myid`))
+	require.Equal(t, []int{1, 4}, byteIDs)
+}
+
+func TestDumpTablesForeignKeyOrder(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	srcdb := ownerdb.TempDatabase()
+	dstdb := ownerdb.TempDatabase()
+
+	schema := `
+		create table parent (id integer primary key);
+		create table child (id integer primary key, parent_id integer not null references parent (id));
+		create table grandchild (id integer primary key, child_id integer not null references child (id));
+	`
+
+	// Prepare source database
+	_, err := srcdb.Exec(schema)
+	require.NoError(t, err)
+
+	_, err = srcdb.Exec(`
+		insert into parent (id) values (1), (2);
+		insert into child (id, parent_id) values (10, 1), (20, 2);
+		insert into grandchild (id, child_id) values (100, 10);
+	`)
+	require.NoError(t, err)
+
+	// Prepare destination database
+	_, err = dstdb.Exec(schema)
+	require.NoError(t, err)
+
+	sorted, err := pgdump.SortTables(pgdump.NewQuerier(srcdb), []string{"grandchild", "child", "parent"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"parent", "child", "grandchild"}, sorted)
+
+	var dump strings.Builder
+	dumpFunc := func(st string) error {
+		_, err := dump.WriteString(st)
+		return err
+	}
+	require.NoError(t, pgdump.DumpTables(dumpFunc, pgdump.NewQuerier(srcdb), []string{"grandchild", "child", "public.*"}, &pgdump.Options{}))
+
+	// Insert dumped rows
+	_, err = dstdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, dstdb.QueryRow(`select count(*) from grandchild`).Scan(&count))
+	require.Equal(t, 1, count)
+}
+
+func TestSortTablesCycle(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	_, err := tempdb.Exec(`
+		create table a (id integer primary key, b_id integer);
+		create table b (id integer primary key, a_id integer references a (id));
+		alter table a add foreign key (b_id) references b (id) deferrable;
+	`)
+	require.NoError(t, err)
+
+	_, err = pgdump.SortTables(pgdump.NewQuerier(tempdb), []string{"a", "b"})
+	var cycle *pgdump.CycleError
+	require.True(t, errors.As(err, &cycle))
+	require.Equal(t, []string{"a", "b"}, cycle.Tables)
+
+	var dump strings.Builder
+	dumpFunc := func(st string) error {
+		_, err := dump.WriteString(st)
+		return err
+	}
+	require.NoError(t, pgdump.DumpTables(dumpFunc, pgdump.NewQuerier(tempdb), []string{"a", "b"}, &pgdump.Options{DeferConstraints: true}))
+	require.True(t, strings.HasPrefix(dump.String(), "SET CONSTRAINTS ALL DEFERRED;\n"))
+}
+
+func TestDumpSubset(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	srcdb := ownerdb.TempDatabase()
+	dstdb := ownerdb.TempDatabase()
+
+	schema := `
+		create table product (id integer primary key);
+		create table customer (id integer primary key);
+		create table orders (id integer primary key, customer_id integer not null references customer (id), referred_by_customer_id integer references customer (id));
+		create table item (id integer primary key, order_id integer not null references orders (id), product_id integer not null references product (id));
+		create table note (id integer primary key, customer_id integer not null references customer (id));
+	`
+
+	// Prepare source database
+	_, err := srcdb.Exec(schema)
+	require.NoError(t, err)
+
+	_, err = srcdb.Exec(`
+		insert into product (id) values (1), (2), (3);
+		insert into customer (id) values (1), (2), (3);
+		insert into orders (id, customer_id, referred_by_customer_id) values (10, 1, 2), (20, 2, null);
+		insert into item (id, order_id, product_id) values (100, 10, 1), (200, 20, 2);
+		insert into note (id, customer_id) values (1000, 1);
+	`)
+	require.NoError(t, err)
+
+	// Prepare destination database
+	_, err = dstdb.Exec(schema)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.Query = "WHERE id = $1"
+	opts.QueryArgs = []interface{}{1}
+
+	var subset pgdump.SubsetOptions
+	subset.Exclude = []string{"note"}
+
+	var dump strings.Builder
+	dumpFunc := func(st string) error {
+		_, err := dump.WriteString(st)
+		return err
+	}
+	require.NoError(t, pgdump.DumpSubset(dumpFunc, pgdump.NewQuerier(srcdb), "customer", &opts, &subset))
+
+	// Insert dumped rows
+	_, err = dstdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	// Customer 2 is referenced by an order of customer 1
+	for table, expected := range map[string][]int{
+		"customer": {1, 2},
+		"orders":   {10},
+		"item":     {100},
+		"product":  {1},
+		"note":     nil,
+	} {
+		var ids []int
+		require.NoError(t, sqlscan.Select(context.Background(), dstdb, &ids, `select id from `+table+` order by id`))
+		require.ElementsMatch(t, expected, ids, table)
+	}
+
+	// Only follow one foreign key to referencing rows
+	dump.Reset()
+	subset.Depth = 1
+	require.NoError(t, pgdump.DumpSubset(dumpFunc, pgdump.NewQuerier(srcdb), "customer", &opts, &subset))
+	require.Contains(t, dump.String(), "INSERT INTO orders")
+	require.NotContains(t, dump.String(), "INSERT INTO item")
+
+	// Self-references are followed to the top of the chain
+	for _, db := range []*sql.DB{srcdb, dstdb} {
+		_, err = db.Exec(`create table employee (id integer primary key, manager_id integer references employee (id))`)
+		require.NoError(t, err)
+	}
+
+	_, err = srcdb.Exec(`insert into employee (id, manager_id) values (1, null), (2, 1), (3, 2), (4, 3), (5, 4), (6, 1)`)
+	require.NoError(t, err)
+
+	dump.Reset()
+	opts.QueryArgs = []interface{}{4}
+	require.NoError(t, pgdump.DumpSubset(dumpFunc, pgdump.NewQuerier(srcdb), "employee", &opts, nil))
+
+	_, err = dstdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	var ids []int
+	require.NoError(t, sqlscan.Select(context.Background(), dstdb, &ids, `select id from employee order by id`))
+	require.Equal(t, []int{1, 2, 3, 4, 5}, ids)
+}
+
+func TestDumpSequences(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	srcdb := ownerdb.TempDatabase()
+	dstdb := ownerdb.TempDatabase()
+
+	schema := `create table account (id serial primary key, num bigint generated by default as identity, name text not null);`
+
+	// Prepare source database
+	_, err := srcdb.Exec(schema)
+	require.NoError(t, err)
+
+	_, err = srcdb.Exec(`
+		insert into account (name) values ('a'), ('b'), ('c');
+		delete from account where id = 3;
+	`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		mode pgdump.SequenceMode
+		next int
+	}{
+		{pgdump.MaxSequences, 3},
+		{pgdump.SourceSequences, 4},
+	} {
+		// Prepare destination database
+		_, err = dstdb.Exec(`drop table if exists account;` + schema)
+		require.NoError(t, err)
+
+		var opts pgdump.Options
+		opts.Sequences = tc.mode
+
+		var dump strings.Builder
+		require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(srcdb), "account", &opts))
+
+		// Insert dumped rows
+		_, err = dstdb.Exec(dump.String())
+		require.NoError(t, err)
+
+		var id, num int
+		require.NoError(t, dstdb.QueryRow(`insert into account (name) values ('d') returning id, num`).Scan(&id, &num))
+		require.Equal(t, tc.next, id)
+		require.Equal(t, tc.next, num)
+	}
+}
+
+func TestDumpCreateTable(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	srcdb := ownerdb.TempDatabase()
+	dstdb := ownerdb.TempDatabase()
+
+	// Prepare source database
+	_, err := srcdb.Exec(schemaWithGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = srcdb.Exec(`alter table mytable add column mycode varchar(8) not null default 'x', add column myserial bigserial`)
+	require.NoError(t, err)
+
+	_, err = srcdb.Exec(rows)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.CreateTable = true
+	opts.ConflictColumn = "mycode"
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(srcdb), "mytable", &opts))
+	require.Contains(t, dump.String(), `"mycode" character varying(8) DEFAULT 'x'::character varying NOT NULL`)
+	require.Contains(t, dump.String(), `"myserial" bigserial NOT NULL`)
+	require.Contains(t, dump.String(), `PRIMARY KEY ("myid")`)
+	require.Contains(t, dump.String(), `UNIQUE ("mycode")`)
+
+	// Primary key is already unique
+	opts.ConflictColumn = "myid"
+	dump.Reset()
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(srcdb), "mytable", &opts))
+	require.NotContains(t, dump.String(), "UNIQUE")
+
+	// Create table and insert dumped rows
+	_, err = dstdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, dstdb.QueryRow(`select count(*) from mytable`).Scan(&count))
+	require.Equal(t, 2, count)
+}
+
+func TestDumpPages(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(`insert into mytable (myid, mytext, myinteger, myfloat) select i, 'row ' || i, i, i from generate_series(1, 10) i`)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertColumns = []string{"myid", "mytext"}
+	opts.Query = "WHERE myinteger <> $1"
+	opts.QueryArgs = []interface{}{5}
+	opts.PageSize = 3
+
+	rows, err := pgdump.Query(pgdump.NewQuerier(tempdb), "mytable", &opts)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		ids = append(ids, rows.Values()[0].(int64))
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []int64{1, 2, 3, 4, 6, 7, 8, 9, 10}, ids)
+
+	// Resume after a key
+	opts.PageAfter = []interface{}{int64(6)}
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+	require.Equal(t, 4, strings.Count(dump.String(), "INSERT INTO"))
+}
+
+func TestDumpParallel(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(`insert into mytable (myid, mytext, myinteger, myfloat) select i, 'row ' || i, i, i from generate_series(1, 10) i`)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertColumns = []string{"myid"}
+	opts.Query = "WHERE myinteger <> $1"
+	opts.QueryArgs = []interface{}{5}
+
+	dumps := make([]strings.Builder, 3)
+
+	rangeFunc := func(i int) (func(string) error, error) {
+		return func(st string) error {
+			_, err := dumps[i].WriteString(st)
+			return err
+		}, nil
+	}
+
+	require.NoError(t, pgdump.DumpParallel(rangeFunc, tempdb, "mytable", &opts, len(dumps)))
+
+	var all string
+	for i := range dumps {
+		require.NotEmpty(t, dumps[i].String())
+		all += dumps[i].String()
+	}
+
+	require.Equal(t, 9, strings.Count(all, "INSERT INTO"))
+	require.NotContains(t, all, "VALUES (5)")
+	require.Less(t, strings.Index(all, "VALUES (4)"), strings.Index(all, "VALUES (6)"))
+
+	// Keys spanning more than the int64 range
+	_, err = tempdb.Exec(`create table mybigints (myid bigint primary key); insert into mybigints values (-9223372036854775808), (-1), (0), (9223372036854775806), (9223372036854775807)`)
+	require.NoError(t, err)
+
+	for _, jobs := range []int{2, 3, 7} {
+		dumps = make([]strings.Builder, jobs)
+		require.NoError(t, pgdump.DumpParallel(rangeFunc, tempdb, "mybigints", nil, jobs))
+
+		all = ""
+		for i := range dumps {
+			all += dumps[i].String()
+		}
+
+		require.Equal(t, 5, strings.Count(all, "INSERT INTO"), jobs)
+		for _, id := range []string{"-9223372036854775808", "-1", "0", "9223372036854775806", "9223372036854775807"} {
+			require.Contains(t, all, "VALUES ("+id+")", jobs)
+		}
+	}
+}
+
+func TestBeginSnapshot(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source tables
+	_, err := tempdb.Exec(`
+create table parent (id integer primary key);
+create table child (id integer primary key, parent_id integer not null references parent (id));
+insert into parent (id) values (1);
+insert into child (id, parent_id) values (1, 1);
+`)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	tx, err := pgdump.BeginSnapshot(ctx, tempdb, "")
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	var snapshot string
+	require.NoError(t, tx.QueryRow(`SELECT pg_export_snapshot()`).Scan(&snapshot))
+
+	// Rows added after the snapshot are not dumped
+	_, err = tempdb.Exec(`insert into parent (id) values (2); insert into child (id, parent_id) values (2, 2);`)
+	require.NoError(t, err)
+
+	var dump strings.Builder
+	dumpFunc := func(st string) error {
+		_, err := dump.WriteString(st)
+		return err
+	}
+
+	require.NoError(t, pgdump.DumpTables(dumpFunc, pgdump.NewQuerier(tx), []string{"child", "parent"}, nil))
+	require.Equal(t, 2, strings.Count(dump.String(), "INSERT INTO"))
+
+	// Same snapshot in another transaction
+	imported, err := pgdump.BeginSnapshot(ctx, tempdb, snapshot)
+	require.NoError(t, err)
+	defer imported.Rollback()
+
+	var count int
+	require.NoError(t, imported.QueryRow(`SELECT count(*) FROM child`).Scan(&count))
+	require.Equal(t, 1, count)
+}
+
+func TestDumpStreamGzip(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertColumns = []string{"myid", "mytext"}
+	opts.Gzip = true
+	opts.GzipLevel = gzip.BestCompression
+
+	var buf bytes.Buffer
+	require.NoError(t, pgdump.DumpStream(&buf, pgdump.NewQuerier(tempdb), "mytable", &opts))
+
+	r, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+
+	dump, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(dump), "INSERT INTO"))
+
+	// Output is terminated also on errors
+	opts.InsertColumns = []string{"nosuchcolumn"}
+	opts.ConflictColumn = "nosuchcolumn"
+
+	buf.Reset()
+	require.Error(t, pgdump.DumpStream(&buf, pgdump.NewQuerier(tempdb), "mytable", &opts))
+
+	r, err = gzip.NewReader(&buf)
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+}
+
+func TestSplitWriter(t *testing.T) {
+	dir := t.TempDir()
+
+	w := pgdump.SplitWriter{
+		Dir:           dir,
+		MaxStatements: 2,
+		MaxBytes:      40,
+		Header:        "BEGIN;\n",
+		Footer:        "COMMIT;\n",
+	}
+
+	for _, st := range []string{"SELECT 1;\n", "SELECT 2;\n", "SELECT 3;\n", "SELECT 'a long statement';\n", "SELECT 4;\n"} {
+		require.NoError(t, w.WriteStatement(st))
+	}
+	require.NoError(t, w.Close())
+
+	files := w.Files()
+	require.Len(t, files, 4)
+
+	// Limited by statements, then by bytes
+	var counts []int
+	for _, f := range files {
+		counts = append(counts, f.Statements)
+	}
+	require.Equal(t, []int{2, 1, 1, 1}, counts)
+	require.Greater(t, files[2].Bytes, w.MaxBytes)
+
+	data, err := os.ReadFile(filepath.Join(dir, files[1].Name))
+	require.NoError(t, err)
+	require.Equal(t, "BEGIN;\nSELECT 3;\nCOMMIT;\n", string(data))
+	require.Equal(t, int64(len(data)), files[1].Bytes)
+
+	manifest, err := os.ReadFile(filepath.Join(dir, pgdump.ManifestName))
+	require.NoError(t, err)
+	require.Contains(t, string(manifest), `"name": "00004.sql"`)
+}
+
+func TestDumpPageFunc(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(`insert into mytable (myid, mytext, myinteger, myfloat) select i, 'row ' || i, i, i from generate_series(1, 10) i`)
+	require.NoError(t, err)
+
+	var dump strings.Builder
+	dumpFunc := func(st string) error {
+		_, err := dump.WriteString(st)
+		return err
+	}
+
+	var pages []interface{}
+	var counts []int
+
+	var opts pgdump.Options
+	opts.InsertColumns = []string{"myid", "mytext"}
+	opts.PageSize = 3
+	opts.PageFunc = func(after []interface{}) error {
+		pages = append(pages, after[0])
+		counts = append(counts, strings.Count(dump.String(), "INSERT INTO"))
+		return nil
+	}
+
+	require.NoError(t, pgdump.Dump(dumpFunc, pgdump.NewQuerier(tempdb), "mytable", &opts))
+	require.Equal(t, []interface{}{int64(3), int64(6), int64(9), int64(10)}, pages)
+	require.Equal(t, []int{3, 6, 9, 10}, counts)
+}
+
+func TestDumpProgress(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	var progress []pgdump.Progress
+
+	var opts pgdump.Options
+	opts.Query = "WHERE myid > $1"
+	opts.QueryArgs = []interface{}{0}
+	opts.CountRows = true
+	opts.ProgressInterval = time.Nanosecond
+	opts.ProgressFunc = func(p pgdump.Progress) {
+		progress = append(progress, p)
+	}
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+
+	// Intermediate reports depend on timing, the final one is always made
+	require.NotEmpty(t, progress)
+
+	last := progress[len(progress)-1]
+	require.True(t, last.Done)
+	require.Equal(t, "mytable", last.Table)
+	require.Equal(t, int64(2), last.Rows)
+	require.Equal(t, int64(2), last.TotalRows)
+	require.Equal(t, int64(dump.Len()), last.Bytes)
+	require.Equal(t, time.Duration(0), last.ETA())
+
+	for _, p := range progress[:len(progress)-1] {
+		require.False(t, p.Done)
+		require.LessOrEqual(t, p.Rows, last.Rows)
+	}
+
+	// Rows of a complete SELECT query are counted too
+	progress = nil
+	opts.Query = "SELECT myid, mytext FROM mytable WHERE myid > $1"
+	opts.InsertColumns = []string{"myid", "mytext"}
+
+	require.NoError(t, pgdump.DumpStream(io.Discard, pgdump.NewQuerier(tempdb), "mytable", &opts))
+	require.Equal(t, int64(2), progress[len(progress)-1].TotalRows)
+}
+
+func TestDumpStats(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	var dump strings.Builder
+	dumpFunc := func(st string) error {
+		_, err := dump.WriteString(st)
+		return err
+	}
+
+	var opts pgdump.Options
+	opts.InsertColumns = []string{"myid", "mytext", "mytsvector"}
+	opts.ConflictColumn = "myid"
+
+	stats, err := pgdump.DumpStats(dumpFunc, pgdump.NewQuerier(tempdb), "mytable", &opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Rows)
+	require.Equal(t, int64(2), stats.Statements)
+	require.Equal(t, int64(dump.Len()), stats.Bytes)
+	require.Equal(t, map[string]int64{"mytsvector": 1}, stats.NullCounts)
+	require.Equal(t, int64(2), stats.MaxKey)
+	require.LessOrEqual(t, stats.QueryDuration, stats.TotalDuration)
+
+	// Track another column
+	opts.MaxKeyColumn = "mytext"
+
+	stats, err = pgdump.DumpStats(dumpFunc, pgdump.NewQuerier(tempdb), "mytable", &opts)
+	require.NoError(t, err)
+	require.Equal(t, "Bob", stats.MaxKey)
+
+	// Numeric keys are compared by value
+	_, err = tempdb.Exec(`create table mynumerics (mynumeric numeric primary key); insert into mynumerics values (9.5), (10), (-20)`)
+	require.NoError(t, err)
+
+	stats, err = pgdump.DumpStats(dumpFunc, pgdump.NewQuerier(tempdb), "mynumerics", &pgdump.Options{MaxKeyColumn: "mynumeric"})
+	require.NoError(t, err)
+	require.Equal(t, "10", stats.MaxKey)
+}
+
+// recordingLogger records messages by level.
+type recordingLogger struct {
+	messages map[string][]string
+}
+
+func (l *recordingLogger) record(level, msg string) {
+	if l.messages == nil {
+		l.messages = make(map[string][]string)
+	}
+	l.messages[level] = append(l.messages[level], msg)
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.record("debug", msg) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record("info", msg) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record("warn", msg) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record("error", msg) }
+
+// *slog.Logger can be used as logger
+var _ pgdump.Logger = slog.Default()
+
+func TestDumpLogger(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	var logger recordingLogger
+
+	var opts pgdump.Options
+	opts.Logger = &logger
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+	require.Equal(t, []string{"Query"}, logger.messages["debug"])
+	require.Equal(t, []string{"Fetched rows"}, logger.messages["info"])
+}
+
+func TestDumpUpdateColumns(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	tempdb := ownerdb.TempDatabase()
+
+	// Prepare source table
+	_, err := tempdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(rows)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertColumns = []string{"myid", "mytext", "myinteger"}
+	opts.ConflictColumn = "myid"
+	opts.UpdateColumns = []string{"myinteger"}
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts))
+	require.Contains(t, dump.String(), `DO UPDATE SET "myinteger"=EXCLUDED."myinteger";`)
+
+	// Changing text is not applied on conflict
+	_, err = tempdb.Exec(`update mytable set mytext = 'Carol', myinteger = 1`)
+	require.NoError(t, err)
+
+	_, err = tempdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	var texts []string
+	require.NoError(t, sqlscan.Select(context.Background(), tempdb, &texts, `SELECT mytext FROM mytable WHERE myinteger > 1 ORDER BY myid`))
+	require.Equal(t, []string{"Carol", "Carol"}, texts)
+
+	// Unknown update columns fail before any row is output
+	for _, name := range []string{"nosuchcolumn", "myfloat"} {
+		dump.Reset()
+		opts.UpdateColumns = []string{name}
+		err = pgdump.DumpStream(&dump, pgdump.NewQuerier(tempdb), "mytable", &opts)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown update column "+name)
+		require.Empty(t, dump.String())
+	}
+}
+
+func TestCopy(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	srcdb := ownerdb.TempDatabase()
+	dstdb := ownerdb.TempDatabase()
+
+	// Prepare source and target tables, the target rejects Bob
+	_, err := srcdb.Exec(schemaWithoutGeneratedColumn)
+	require.NoError(t, err)
+
+	_, err = srcdb.Exec(rows)
+	require.NoError(t, err)
+
+	_, err = dstdb.Exec(`create table mytable (myid integer primary key, mytext text not null check (mytext <> 'Bob'))`)
+	require.NoError(t, err)
+
+	var opts pgdump.Options
+	opts.InsertColumns = []string{"myid", "mytext"}
+
+	copyOpts := pgdump.CopyOptions{
+		BatchSize:       1,
+		ContinueOnError: true,
+	}
+
+	stats, err := pgdump.Copy(dstdb, pgdump.NewQuerier(srcdb), "mytable", &opts, &copyOpts)
+	require.Error(t, err)
+	require.Equal(t, int64(2), stats.Rows)
+
+	var batchErr *pgdump.BatchError
+	require.True(t, errors.As(err, &batchErr))
+	require.Equal(t, 1, batchErr.Batch)
+	require.Equal(t, "2", batchErr.Key)
+
+	// Other batch was committed
+	var ids []int64
+	require.NoError(t, sqlscan.Select(context.Background(), dstdb, &ids, `SELECT myid FROM mytable`))
+	require.Equal(t, []int64{1}, ids)
+}
+
+func TestDumpDiff(t *testing.T) {
+	// Connect to databases
+	ownerdb := testdb.New(t)
+	srcdb := ownerdb.TempDatabase()
+	dstdb := ownerdb.TempDatabase()
+
+	// Prepare source and target, the target has a changed, a missing and an
+	// extra row
+	for _, db := range []*sql.DB{srcdb, dstdb} {
+		_, err := db.Exec(`create table mytable (myid integer primary key, mytext text not null)`)
+		require.NoError(t, err)
+	}
+
+	_, err := srcdb.Exec(`insert into mytable (myid, mytext) values (1, 'Alice'), (2, 'Bob'), (3, 'Carol')`)
+	require.NoError(t, err)
+
+	_, err = dstdb.Exec(`insert into mytable (myid, mytext) values (1, 'Alice'), (2, 'Robert'), (4, 'Dave')`)
+	require.NoError(t, err)
+
+	var dump strings.Builder
+	dumpFunc := func(st string) error {
+		_, err := dump.WriteString(st)
+		return err
+	}
+
+	diffOpts := pgdump.DiffOptions{KeyColumn: "myid", Deletes: true}
+
+	require.NoError(t, pgdump.DumpDiff(dumpFunc, pgdump.NewQuerier(srcdb), pgdump.NewQuerier(dstdb), "mytable", nil, &diffOpts))
+	require.Equal(t, 1, strings.Count(dump.String(), "INSERT INTO"))
+	require.Equal(t, 1, strings.Count(dump.String(), "UPDATE mytable"))
+	require.Equal(t, 1, strings.Count(dump.String(), "DELETE FROM"))
+
+	// Target is identical after applying the diff
+	_, err = dstdb.Exec(dump.String())
+	require.NoError(t, err)
+
+	var texts []string
+	require.NoError(t, sqlscan.Select(context.Background(), dstdb, &texts, `SELECT mytext FROM mytable ORDER BY myid`))
+	require.Equal(t, []string{"Alice", "Bob", "Carol"}, texts)
+
+	// Changed rows only update the update columns
+	for _, db := range []*sql.DB{srcdb, dstdb} {
+		_, err := db.Exec(`alter table mytable add column mytimestamp timestamptz not null default '2018-06-13 21:10:34.5+00'`)
+		require.NoError(t, err)
+	}
+
+	_, err = srcdb.Exec(`update mytable set mytext = 'Bobby', mytimestamp = '2018-06-14 00:00:00+00' where myid = 2`)
+	require.NoError(t, err)
+
+	dump.Reset()
+	opts := pgdump.Options{UpdateColumns: []string{"mytext"}}
+	require.NoError(t, pgdump.DumpDiff(dumpFunc, pgdump.NewQuerier(srcdb), pgdump.NewQuerier(dstdb), "mytable", &opts, &diffOpts))
+	require.Equal(t, 1, strings.Count(dump.String(), "UPDATE mytable"))
+	require.NotContains(t, dump.String(), "mytimestamp")
+}
+
+func TestDumpNDJSON(t *testing.T) {
+	// Connect to database
+	ownerdb := testdb.New(t)
+	srcdb := ownerdb.TempDatabase()
+
+	_, err := srcdb.Exec(`create table mytable (myid integer primary key, mytext text, mynumeric numeric not null, mybool boolean not null, mydate date not null, mytimestamp timestamp not null, myjsonb jsonb not null, myints integer[] not null, mybytea bytea not null)`)
+	require.NoError(t, err)
+
+	_, err = srcdb.Exec(`insert into mytable values (1, '<Alice>', 1.50, true, '2018-06-13', '2018-06-13 21:10:34.5', '{"a": [1, 2]}', '{1,2}', '\x0102'), (2, null, 'NaN', false, '2018-06-14', '2018-06-14 00:00:00', 'null', '{3}', '\x03')`)
+	require.NoError(t, err)
+
+	opts := pgdump.Options{Query: "WHERE true ORDER BY myid"}
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpNDJSON(&dump, pgdump.NewQuerier(srcdb), "mytable", &opts))
+	require.Equal(t, `{"myid":1,"mytext":"<Alice>","mynumeric":1.50,"mybool":true,"mydate":"2018-06-13","mytimestamp":"2018-06-13T21:10:34.5","myjsonb":{"a":[1,2]},"myints":[1,2],"mybytea":"AQI="}
+{"myid":2,"mytext":null,"mynumeric":"NaN","mybool":false,"mydate":"2018-06-14","mytimestamp":"2018-06-14T00:00:00","myjsonb":null,"myints":[3],"mybytea":"Aw=="}
+`, dump.String())
+}
+
+func TestDumpCSV(t *testing.T) {
+	// Connect to database
+	ownerdb := testdb.New(t)
+	srcdb := ownerdb.TempDatabase()
+
+	_, err := srcdb.Exec(`create table mytable (myid integer primary key, mytext text, mybool boolean not null, myints integer[] not null)`)
+	require.NoError(t, err)
+
+	_, err = srcdb.Exec(`insert into mytable values (1, 'Alice; "Al"', true, '{1,2}'), (2, null, false, '{3}'), (3, 'NULL', false, '{4}'), (4, '', true, '{5}')`)
+	require.NoError(t, err)
+
+	opts := pgdump.Options{
+		Query:         "WHERE myid > 0 ORDER BY myid",
+		InsertColumns: []string{"myid", "mytext", "mybool", "myints"},
+	}
+
+	csvOpts := pgdump.CSVOptions{
+		Header:    true,
+		Delimiter: ';',
+		Null:      "NULL",
+	}
+
+	var dump strings.Builder
+	require.NoError(t, pgdump.DumpCSV(&dump, pgdump.NewQuerier(srcdb), "mytable", &opts, &csvOpts))
+	require.Equal(t, `myid;mytext;mybool;myints
+1;"Alice; ""Al""";true;{1,2}
+2;NULL;false;{3}
+3;"NULL";false;{4}
+4;;true;{5}
+`, dump.String())
+
+	// Delimiter can't appear in NULL representation
+	csvOpts.Null = ";"
+	require.Error(t, pgdump.DumpCSV(&dump, pgdump.NewQuerier(srcdb), "mytable", &opts, &csvOpts))
+
+	// Delimiter must be a single byte
+	csvOpts.Null = "NULL"
+	csvOpts.Delimiter = '§'
+	require.Error(t, pgdump.DumpCSV(&dump, pgdump.NewQuerier(srcdb), "mytable", &opts, &csvOpts))
+
+	// Infinities are written as PostgreSQL reads them
+	_, err = srcdb.Exec(`create table myfloats (myid integer primary key, myfloat double precision not null, myfloats double precision[] not null); insert into myfloats values (1, 'Infinity', '{-Infinity,1.5}')`)
+	require.NoError(t, err)
+
+	dump.Reset()
+	require.NoError(t, pgdump.DumpCSV(&dump, pgdump.NewQuerier(srcdb), "myfloats", nil, nil))
+	require.Equal(t, "1,Infinity,\"{-Infinity,1.5}\"\n", dump.String())
+}
+
+func TestRestore(t *testing.T) {
+	// Connect to database
+	ownerdb := testdb.New(t)
+	dstdb := ownerdb.TempDatabase()
+
+	_, err := dstdb.Exec(`create table mytable (myid integer primary key, mytext text)`)
+	require.NoError(t, err)
+
+	script := `BEGIN;
+-- Semicolons in literals and comments don't end statements;
+INSERT INTO mytable (myid, mytext) VALUES (1, 'Alice;
+Bob');
+INSERT INTO mytable (myid, mytext) VALUES (1, 'Duplicate');
+INSERT INTO mytable (myid, mytext) VALUES (2, E'\\\';'); INSERT INTO mytable (myid, mytext) VALUES (3, $$Carol;$$);
+COMMIT;
+`
+
+	var rejects []*pgdump.RestoreError
+
+	opts := pgdump.RestoreOptions{
+		BatchSize: 3,
+		RejectFunc: func(e *pgdump.RestoreError) error {
+			rejects = append(rejects, e)
+			return nil
+		},
+	}
+
+	stats, err := pgdump.Restore(dstdb, strings.NewReader(script), &opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), stats.Statements)
+	require.Equal(t, int64(1), stats.Rejected)
+	require.Equal(t, 2, stats.Batches)
+
+	require.Len(t, rejects, 1)
+	require.Equal(t, 5, rejects[0].Line)
+	require.Contains(t, rejects[0].Statement, "Duplicate")
+
+	var texts []sql.NullString
+	require.NoError(t, sqlscan.Select(context.Background(), dstdb, &texts, `SELECT mytext FROM mytable ORDER BY myid`))
+	require.Equal(t, []sql.NullString{
+		{String: "Alice;\nBob", Valid: true},
+		{String: `\';`, Valid: true},
+		{String: "Carol;", Valid: true},
+	}, texts)
+
+	// Without RejectFunc the first failure stops the restore
+	_, err = pgdump.Restore(dstdb, strings.NewReader(script), nil)
+
+	var restoreErr *pgdump.RestoreError
+	require.True(t, errors.As(err, &restoreErr))
+	require.Equal(t, 3, restoreErr.Line)
+
+	// COPY data is not supported
+	_, err = pgdump.Restore(dstdb, strings.NewReader("COPY mytable (myid, mytext) FROM stdin;\n6\tFrank\n\\.\n"), nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "COPY FROM STDIN is not supported")
+}
+
+func TestRestoreDeferred(t *testing.T) {
+	// Connect to database
+	ownerdb := testdb.New(t)
+	dstdb := ownerdb.TempDatabase()
+
+	_, err := dstdb.Exec(`
+		create table a (id integer primary key, b_id integer not null);
+		create table b (id integer primary key, a_id integer not null references a (id) deferrable);
+		alter table a add foreign key (b_id) references b (id) deferrable;
+	`)
+	require.NoError(t, err)
+
+	// Deferred checks only pass once both rows are in place, even though
+	// each batch holds a single statement
+	script := `BEGIN;
+SET CONSTRAINTS ALL DEFERRED;
+PREPARE insert_a AS INSERT INTO a (id, b_id) VALUES ($1, $2);
+EXECUTE insert_a (1, 1);
+DEALLOCATE insert_a;
+INSERT INTO b (id, a_id) VALUES (1, 1);
+COMMIT;
+`
+
+	opts := pgdump.RestoreOptions{BatchSize: 1}
+
+	stats, err := pgdump.Restore(dstdb, strings.NewReader(script), &opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), stats.Statements)
+	require.Equal(t, 1, stats.Batches)
+
+	// A failing deferred check rejects the transaction as a whole
+	var rejects []*pgdump.RestoreError
+	opts.RejectFunc = func(e *pgdump.RestoreError) error {
+		rejects = append(rejects, e)
+		return nil
+	}
+
+	script = `SET CONSTRAINTS ALL DEFERRED;
+INSERT INTO a (id, b_id) VALUES (2, 2);
+`
+
+	stats, err = pgdump.Restore(dstdb, strings.NewReader(script), &opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.Rejected)
+	require.Len(t, rejects, 1)
+	require.Equal(t, 1, rejects[0].Line)
+	require.Contains(t, rejects[0].Statement, "VALUES (2, 2)")
+
+	var ids []int
+	require.NoError(t, sqlscan.Select(context.Background(), dstdb, &ids, `SELECT id FROM a`))
+	require.Equal(t, []int{1}, ids)
+
+	// Long transactions are reported in part
+	var long strings.Builder
+	long.WriteString("SET CONSTRAINTS ALL DEFERRED;\n")
+	for i := 10; i < 3010; i++ {
+		fmt.Fprintf(&long, "INSERT INTO a (id, b_id) VALUES (%d, %d);\n", i, i)
+	}
+
+	rejects = nil
+	opts.BatchSize = 100
+
+	stats, err = pgdump.Restore(dstdb, strings.NewReader(long.String()), &opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(3001), stats.Rejected)
+	require.Len(t, rejects, 1)
+	require.Less(t, len(rejects[0].Statement), 65<<10)
+	require.Contains(t, rejects[0].Statement, "more statements not shown")
+}
@@ -0,0 +1,150 @@
+package pgdump
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultProgressInterval is how often ProgressFunc is called by default.
+const DefaultProgressInterval = time.Second
+
+// Progress describes how far the dump of a table has come, see
+// Options.ProgressFunc.
+type Progress struct {
+	// Table being dumped
+	Table string
+
+	// Number of rows fetched so far
+	Rows int64
+
+	// Number of bytes of statements output so far
+	Bytes int64
+
+	// Estimated total number of rows, -1 if unknown
+	TotalRows int64
+
+	// Time since the dump of the table started
+	Elapsed time.Duration
+
+	// Whether all rows have been fetched
+	Done bool
+}
+
+// Rate returns the number of rows fetched per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Rows) / p.Elapsed.Seconds()
+}
+
+// ETA returns the estimated time left, -1 if unknown.
+func (p Progress) ETA() time.Duration {
+	if p.Done {
+		return 0
+	}
+
+	rate := p.Rate()
+	if p.TotalRows < 0 || rate == 0 {
+		return -1
+	}
+
+	if p.Rows >= p.TotalRows {
+		return 0
+	}
+
+	return time.Duration(float64(p.TotalRows-p.Rows) / rate * float64(time.Second))
+}
+
+// progress calls Options.ProgressFunc as rows are dumped.
+type progress struct {
+	fn       func(Progress)
+	stats    *Stats
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+	p        Progress
+}
+
+// newProgress estimates the number of rows to dump from sourceTable. Without
+// a filter the estimate is pg_class.reltuples, otherwise rows are only counted
+// if CountRows is set in opts. Rows and bytes are taken from stats.
+func newProgress(q Querier, sourceTable, table string, opts *Options, stats *Stats) (*progress, error) {
+	total, err := estimateRows(q, sourceTable, opts)
+	if err != nil {
+		return nil, fmt.Errorf("estimate rows: %w", err)
+	}
+
+	interval := opts.ProgressInterval
+	if interval == 0 {
+		interval = DefaultProgressInterval
+	}
+
+	now := time.Now()
+
+	return &progress{
+		fn:       opts.ProgressFunc,
+		stats:    stats,
+		interval: interval,
+		start:    now,
+		last:     now,
+		p:        Progress{Table: table, TotalRows: total},
+	}, nil
+}
+
+func estimateRows(q Querier, sourceTable string, opts *Options) (int64, error) {
+	if opts.Query != "" {
+		if !opts.CountRows {
+			return -1, nil
+		}
+
+		// The WHERE clause may end with ORDER BY or LIMIT
+		st := opts.Query
+		if sourceTable != "" && isWhereClause(st) {
+			st = `SELECT 1 FROM ` + sourceTable + ` ` + st
+		}
+
+		var count int64
+		err := q.QueryRow(`SELECT count(*) FROM (`+st+`) s`, opts.QueryArgs...).Scan(&count)
+		return count, err
+	}
+
+	if opts.CountRows {
+		var count int64
+		err := q.QueryRow(`SELECT count(*) FROM ` + sourceTable).Scan(&count)
+		return count, err
+	}
+
+	// Negative if the table has never been analyzed
+	var reltuples float64
+	if err := q.QueryRow(`SELECT reltuples FROM pg_class WHERE oid = $1::regclass`, sourceTable).Scan(&reltuples); err != nil {
+		return 0, err
+	}
+
+	if reltuples < 0 {
+		return -1, nil
+	}
+
+	return int64(reltuples), nil
+}
+
+// row reports progress after a row was dumped if the interval has passed.
+func (p *progress) row() {
+	if now := time.Now(); now.Sub(p.last) >= p.interval {
+		p.last = now
+		p.report(now)
+	}
+}
+
+// done reports final progress.
+func (p *progress) done() {
+	p.p.Done = true
+	p.report(time.Now())
+}
+
+func (p *progress) report(now time.Time) {
+	p.p.Rows = p.stats.Rows
+	p.p.Bytes = p.stats.Bytes
+	p.p.Elapsed = now.Sub(p.start)
+	p.fn(p.p)
+}
@@ -0,0 +1,564 @@
+package pgdump
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+// DefaultRestoreRetries is the default number of times Restore retries a batch
+// failing with a serialization failure or deadlock.
+const DefaultRestoreRetries = 5
+
+// restoreRetryDelay is the delay before the first retry, increasing linearly
+// with each attempt.
+const restoreRetryDelay = 100 * time.Millisecond
+
+var (
+	// txControl matches transaction control statements, which Restore
+	// replaces with its own transactions.
+	txControl = regexp.MustCompile(`(?i)^(BEGIN|START\s+TRANSACTION|COMMIT|END)(\s+(WORK|TRANSACTION))?\s*;$`)
+
+	// deferConstraints matches SET CONSTRAINTS statements deferring checks
+	// until the end of the transaction.
+	deferConstraints = regexp.MustCompile(`(?is)^SET\s+CONSTRAINTS\s.*\sDEFERRED\s*;$`)
+
+	// prepareName and deallocateName match PREPARE and DEALLOCATE
+	// statements, which are not rolled back with the transaction.
+	prepareName    = regexp.MustCompile(`(?i)^PREPARE\s+("(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*)`)
+	deallocateName = regexp.MustCompile(`(?i)^DEALLOCATE\s+(?:PREPARE\s+)?("(?:[^"]|"")+"|[A-Za-z_][A-Za-z0-9_$]*)\s*;$`)
+)
+
+// RestoreOptions controls how Restore applies a script.
+type RestoreOptions struct {
+	// Max number of statements per transaction. Defaults to
+	// DefaultCopyBatchSize.
+	BatchSize int
+
+	// Number of times to retry a batch failing with a serialization failure
+	// or deadlock. Defaults to DefaultRestoreRetries, negative for none.
+	// Batches after constraints are deferred are not retried.
+	MaxRetries int
+
+	// Called for each statement that fails, after which the statement is
+	// skipped and the rest of its batch committed. If the commit itself
+	// fails, e.g. on deferred constraints, the whole batch, or transaction
+	// since constraints were deferred, is rejected as one.
+	// If nil, Restore stops at the first failure and returns its
+	// *RestoreError.
+	RejectFunc func(*RestoreError) error
+}
+
+// RestoreStats summarizes a Restore.
+type RestoreStats struct {
+	// Number of statements committed and rejected
+	Statements int64
+	Rejected   int64
+
+	// Number of committed transactions
+	Batches int
+
+	// Number of batches retried after serialization failures
+	Retries int
+}
+
+// RestoreError describes a statement Restore failed to apply.
+type RestoreError struct {
+	// Line number of the first line of the statement
+	Line int
+
+	// Failing statement, or all statements of a batch that failed to
+	// commit. For a transaction since constraints were deferred, statements
+	// after the first 64 KiB are replaced by a comment with their number.
+	Statement string
+
+	Err error
+}
+
+func (e *RestoreError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RestoreError) Unwrap() error {
+	return e.Err
+}
+
+// Restore applies the SQL script read from r, such as the output of Dump, to
+// db in transactions of up to BatchSize statements on a single connection.
+// COPY ... FROM STDIN is not supported. BEGIN and COMMIT statements in the script are skipped. Batches don't end
+// between a PREPARE and the matching DEALLOCATE.
+//
+// A batch failing with a serialization failure or deadlock is retried. If a
+// statement fails otherwise, its batch is applied again with the statement
+// skipped and the failure reported to RejectFunc.
+//
+// Once constraints are deferred with SET CONSTRAINTS, the rest of the script
+// is applied in one transaction so that deferred checks only run when all
+// rows are in place. Its batches are executed as they are read, each in a
+// savepoint, so failing statements are still skipped, but they are not
+// retried. If the transaction fails to commit, all its statements are
+// rejected as one.
+func Restore(db *sql.DB, r io.Reader, opts *RestoreOptions) (*RestoreStats, error) {
+	if opts == nil {
+		opts = &RestoreOptions{}
+	}
+
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rs := &restorer{
+		ctx:      ctx,
+		conn:     conn,
+		size:     opts.BatchSize,
+		retries:  opts.MaxRetries,
+		reject:   opts.RejectFunc,
+		stats:    &RestoreStats{},
+		prepared: make(map[string]bool),
+	}
+
+	if rs.size <= 0 {
+		rs.size = DefaultCopyBatchSize
+	}
+
+	if rs.retries == 0 {
+		rs.retries = DefaultRestoreRetries
+	}
+
+	// Roll back a deferred transaction left open by an error
+	defer rs.rollback()
+
+	sr := newScriptReader(r)
+
+	for {
+		st, err := sr.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return rs.stats, err
+		}
+
+		if err := rs.add(st); err != nil {
+			return rs.stats, err
+		}
+	}
+
+	if err := rs.flush(); err != nil {
+		return rs.stats, err
+	}
+
+	return rs.stats, rs.commit()
+}
+
+// restoreStatementLimit is the max length of the statements reported when a
+// deferred transaction fails to commit.
+const restoreStatementLimit = 64 << 10
+
+// restorer applies statements in batches.
+type restorer struct {
+	ctx     context.Context
+	conn    *sql.Conn
+	size    int
+	retries int
+	reject  func(*RestoreError) error
+	stats   *RestoreStats
+
+	// Prepared statements created by the script and not yet deallocated
+	prepared map[string]bool
+
+	// Statements of current batch
+	stmts []*scriptStatement
+
+	// Transaction open since constraints were deferred, nil before
+	tx *sql.Tx
+
+	// Line of the SET CONSTRAINTS statement that opened tx
+	txLine int
+
+	// Number of statements applied in tx
+	txStatements int64
+
+	// Statements applied in tx up to restoreStatementLimit, and the number
+	// of statements left out
+	txText    strings.Builder
+	txOmitted int
+}
+
+// add appends st to the current batch, executing the batch when full.
+func (rs *restorer) add(st *scriptStatement) error {
+	if txControl.MatchString(st.text) {
+		return nil
+	}
+
+	if rs.tx == nil && deferConstraints.MatchString(st.text) {
+		// Statements so far are committed as usual
+		if err := rs.flush(); err != nil {
+			return err
+		}
+		if err := rs.begin(st.line); err != nil {
+			return err
+		}
+	}
+
+	if m := prepareName.FindStringSubmatch(st.text); m != nil {
+		rs.prepared[foldIdentifier(m[1])] = true
+	} else if m := deallocateName.FindStringSubmatch(st.text); m != nil {
+		delete(rs.prepared, foldIdentifier(m[1]))
+	}
+
+	rs.stmts = append(rs.stmts, st)
+
+	if len(rs.stmts) >= rs.size && len(rs.prepared) == 0 {
+		return rs.flush()
+	}
+
+	return nil
+}
+
+// flush executes the current batch, retrying serialization failures. If a
+// statement fails, the batch is executed again statement by statement, each
+// in a savepoint, to skip the failing ones.
+func (rs *restorer) flush() error {
+	if len(rs.stmts) == 0 {
+		return nil
+	}
+
+	if rs.tx != nil {
+		return rs.flushDeferred()
+	}
+
+	isolate := false
+
+	for attempt := 0; ; attempt++ {
+		rejects, err := rs.exec(isolate)
+
+		if err != nil && isRetryable(err) && attempt < rs.retries {
+			rs.stats.Retries++
+			time.Sleep(time.Duration(attempt+1) * restoreRetryDelay)
+			continue
+		}
+
+		var commitErr *commitError
+		if errors.As(err, &commitErr) {
+			// Rolled back as a whole, no single statement to blame
+			var buf strings.Builder
+			for _, st := range rs.stmts {
+				buf.WriteString(st.String())
+			}
+
+			restoreErr := &RestoreError{Line: rs.stmts[0].line, Statement: buf.String(), Err: commitErr}
+			if rs.reject == nil {
+				return restoreErr
+			}
+
+			if err := rs.reject(restoreErr); err != nil {
+				return err
+			}
+
+			rs.stats.Rejected += int64(len(rs.stmts))
+
+			break
+		}
+
+		var restoreErr *RestoreError
+		if !isolate && errors.As(err, &restoreErr) {
+			// Find failing statements
+			isolate = true
+			attempt = -1
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, reject := range rejects {
+			if err := rs.reject(reject); err != nil {
+				return err
+			}
+		}
+
+		rs.stats.Statements += int64(len(rs.stmts) - len(rejects))
+		rs.stats.Rejected += int64(len(rejects))
+		rs.stats.Batches++
+
+		break
+	}
+
+	rs.stmts = rs.stmts[:0]
+
+	return nil
+}
+
+// exec executes the current batch in a transaction. Unless isolate is set,
+// statements are executed together and any failure returned as
+// *RestoreError. Otherwise failing statements are rolled back and returned
+// as rejects if there is a RejectFunc.
+func (rs *restorer) exec(isolate bool) ([]*RestoreError, error) {
+	tx, err := rs.conn.BeginTx(rs.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	committed := false
+
+	defer func() {
+		if !committed {
+			tx.Rollback()
+			rs.deallocate()
+		}
+	}()
+
+	rejects, err := rs.run(tx, isolate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rolled back on failure
+	committed = true
+
+	if err := tx.Commit(); err != nil {
+		rs.deallocate()
+		return nil, &commitError{err}
+	}
+
+	return rejects, nil
+}
+
+// run executes the current batch in tx, see exec.
+func (rs *restorer) run(tx *sql.Tx, isolate bool) ([]*RestoreError, error) {
+	var rejects []*RestoreError
+	var joined []string
+
+	for _, st := range rs.stmts {
+		if !isolate {
+			joined = append(joined, st.text)
+			continue
+		}
+
+		if _, err := tx.ExecContext(rs.ctx, "SAVEPOINT restore"); err != nil {
+			return nil, err
+		}
+
+		if _, err := tx.ExecContext(rs.ctx, st.text); err != nil {
+			restoreErr := &RestoreError{Line: st.line, Statement: st.String(), Err: err}
+
+			if isRetryable(err) || rs.reject == nil {
+				return nil, restoreErr
+			}
+
+			if _, err := tx.ExecContext(rs.ctx, "ROLLBACK TO SAVEPOINT restore"); err != nil {
+				return nil, err
+			}
+
+			rejects = append(rejects, restoreErr)
+		}
+	}
+
+	if len(joined) > 0 {
+		if _, err := tx.ExecContext(rs.ctx, strings.Join(joined, "\n")); err != nil {
+			return nil, &RestoreError{Line: rs.stmts[0].line, Statement: joined[0], Err: err}
+		}
+	}
+
+	return rejects, nil
+}
+
+// begin opens the transaction applying the rest of the script, starting with
+// the SET CONSTRAINTS statement at line.
+func (rs *restorer) begin(line int) error {
+	tx, err := rs.conn.BeginTx(rs.ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	rs.tx = tx
+	rs.txLine = line
+	rs.txStatements = 0
+	rs.txText.Reset()
+	rs.txOmitted = 0
+
+	return nil
+}
+
+// flushDeferred executes the current batch in the open transaction, in a
+// savepoint so that a failing batch can be executed again statement by
+// statement. Earlier batches are gone, so failures are not retried.
+func (rs *restorer) flushDeferred() error {
+	for isolate := false; ; isolate = true {
+		if _, err := rs.tx.ExecContext(rs.ctx, "SAVEPOINT restore_batch"); err != nil {
+			return err
+		}
+
+		rejects, err := rs.run(rs.tx, isolate)
+
+		var restoreErr *RestoreError
+		if err != nil && !isolate && !isRetryable(err) && errors.As(err, &restoreErr) {
+			// Find failing statements
+			if _, err := rs.tx.ExecContext(rs.ctx, "ROLLBACK TO SAVEPOINT restore_batch"); err != nil {
+				return err
+			}
+			rs.deallocate()
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := rs.tx.ExecContext(rs.ctx, "RELEASE SAVEPOINT restore_batch"); err != nil {
+			return err
+		}
+
+		for _, reject := range rejects {
+			if err := rs.reject(reject); err != nil {
+				return err
+			}
+		}
+
+		rs.stats.Rejected += int64(len(rejects))
+		rs.txStatements += int64(len(rs.stmts) - len(rejects))
+		rs.keep(rejects)
+
+		break
+	}
+
+	rs.stmts = rs.stmts[:0]
+
+	return nil
+}
+
+// keep records the statements of the current batch applied in the open
+// transaction, for reporting a failed commit.
+func (rs *restorer) keep(rejects []*RestoreError) {
+	for _, st := range rs.stmts {
+		text := st.String()
+
+		rejected := false
+		for _, reject := range rejects {
+			if reject.Line == st.line && reject.Statement == text {
+				rejected = true
+				break
+			}
+		}
+
+		if rejected {
+			continue
+		}
+
+		if rs.txOmitted > 0 || rs.txText.Len()+len(text) > restoreStatementLimit {
+			rs.txOmitted++
+		} else {
+			rs.txText.WriteString(text)
+		}
+	}
+}
+
+// commit commits the open transaction, if any. If the commit fails, its
+// statements are rejected as one.
+func (rs *restorer) commit() error {
+	if rs.tx == nil {
+		return nil
+	}
+
+	tx := rs.tx
+	rs.tx = nil
+
+	if err := tx.Commit(); err != nil {
+		rs.deallocateAll()
+
+		statement := rs.txText.String()
+		if rs.txOmitted > 0 {
+			statement += fmt.Sprintf("-- %d more statements not shown\n", rs.txOmitted)
+		}
+
+		restoreErr := &RestoreError{Line: rs.txLine, Statement: statement, Err: &commitError{err}}
+		if rs.reject == nil {
+			return restoreErr
+		}
+
+		if err := rs.reject(restoreErr); err != nil {
+			return err
+		}
+
+		rs.stats.Rejected += rs.txStatements
+
+		return nil
+	}
+
+	rs.stats.Statements += rs.txStatements
+	rs.stats.Batches++
+
+	return nil
+}
+
+// rollback rolls back the open transaction, if any.
+func (rs *restorer) rollback() {
+	if rs.tx != nil {
+		rs.tx.Rollback()
+		rs.tx = nil
+		rs.deallocateAll()
+	}
+}
+
+// deallocate removes statements prepared by the current batch, which
+// survive a rollback. Statements not prepared yet or already deallocated
+// are ignored.
+func (rs *restorer) deallocate() {
+	for _, st := range rs.stmts {
+		if m := prepareName.FindStringSubmatch(st.text); m != nil {
+			rs.conn.ExecContext(rs.ctx, "DEALLOCATE "+m[1])
+		}
+	}
+}
+
+// deallocateAll removes all statements prepared by the script and not
+// deallocated by it.
+func (rs *restorer) deallocateAll() {
+	for name := range rs.prepared {
+		rs.conn.ExecContext(rs.ctx, "DEALLOCATE "+pq.QuoteIdentifier(name))
+		delete(rs.prepared, name)
+	}
+}
+
+// foldIdentifier returns the name an identifier refers to, lower-case unless
+// quoted.
+func foldIdentifier(ident string) string {
+	if strings.HasPrefix(ident, `"`) {
+		return strings.ReplaceAll(ident[1:len(ident)-1], `""`, `"`)
+	}
+	return strings.ToLower(ident)
+}
+
+// commitError is returned by exec if the transaction failed to commit.
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return fmt.Sprintf("commit: %v", e.err)
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
+
+// isRetryable reports whether err is a serialization failure or deadlock.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package pgdump
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Rows is an iterator over the rows selected for dumping, see Query.
+type Rows struct {
+	q    Querier
+	rows *sql.Rows
+
+	// Set when fetching rows in pages
+	pager *pager
+
+	logger Logger
+
+	// Fetched columns
+	cols []column
+
+	// Scan destinations for cols, see getScanDest().
+	dest []interface{}
+
+	// Name of table to insert into
+	table string
+
+	// Number of rows fetched so far
+	count int
+
+	err error
+}
+
+// Query fetches the rows Dump would output for sourceTable. Columns and rows
+// are selected according to InsertTable, Query, QueryArgs, InsertColumns,
+// PageSize and PageAfter in opts.
+//
+// If sourceTable is empty, Query must be a complete SELECT statement and the
+// column list is derived from its result set. Each result column must then
+// exist in InsertTable, which is looked up in the source database.
+func Query(q Querier, sourceTable string, opts *Options) (*Rows, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	destTable := opts.InsertTable
+	if destTable == "" {
+		destTable = sourceTable
+	}
+
+	var cols, destCols []column
+	var pager *pager
+	var err error
+
+	// Query rows to dump
+	st := opts.Query
+	args := opts.QueryArgs
+
+	if sourceTable == "" {
+		if destTable == "" {
+			return nil, fmt.Errorf("no source or insert table")
+		}
+
+		if st == "" || isWhereClause(st) {
+			return nil, fmt.Errorf("query must be a SELECT statement when no source table is given")
+		}
+
+		if opts.PageSize > 0 {
+			return nil, fmt.Errorf("pagination: paginated fetch requires a source table")
+		}
+
+		// Ask database for column list of destination table
+		destCols, err = getColumns(q, destTable, &Options{})
+
+		if err != nil {
+			return nil, fmt.Errorf("get columns: %w", err)
+		}
+	} else {
+		// Ask database for column list for this table
+		cols, err = getColumns(q, sourceTable, opts)
+
+		if err != nil {
+			return nil, fmt.Errorf("get columns: %w", err)
+		}
+
+		// Verify insert/conflict columns exist
+		if err := validateColumns(cols, opts); err != nil {
+			return nil, fmt.Errorf("validate columns: %w", err)
+		}
+
+		if opts.PageSize > 0 {
+			if pager, err = newPager(q, sourceTable, cols, opts); err != nil {
+				return nil, fmt.Errorf("pagination: %w", err)
+			}
+
+			st, args = pager.query()
+		} else if st == "" {
+			st = getQueryStatement(sourceTable, cols)
+		} else if isWhereClause(st) {
+			where := st
+			st = getQueryStatement(sourceTable, cols) + " " + where
+		}
+	}
+
+	logger := getLogger(opts)
+	logQuery(logger, st, args)
+
+	rows, err := q.Query(st, args...)
+
+	if err != nil {
+		return nil, fmt.Errorf("query rows: %w", err)
+	}
+
+	if sourceTable == "" {
+		// Derive column list from result set
+		cols, err = getResultColumns(rows, destCols, destTable)
+
+		if err == nil {
+			err = validateColumns(cols, opts)
+		}
+
+		if err == nil {
+			for _, col := range cols {
+				if !col.insert {
+					err = fmt.Errorf("result column %s not in insert columns", col.Name)
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("validate columns: %w", err)
+		}
+	}
+
+	for i := range cols {
+		if cols[i].insert {
+			cols[i].bind()
+		}
+	}
+
+	return &Rows{
+		q:      q,
+		rows:   rows,
+		pager:  pager,
+		logger: logger,
+		cols:   cols,
+		dest:   getScanDest(cols),
+		table:  destTable,
+	}, nil
+}
+
+func logQuery(logger Logger, st string, args []interface{}) {
+	if len(args) > 0 {
+		logger.Debug("Query", "statement", st, "args", args)
+	} else {
+		logger.Debug("Query", "statement", st)
+	}
+}
+
+// Columns returns the fetched columns in the order their values are returned
+// by Values.
+func (r *Rows) Columns() []Column {
+	var cols []Column
+	for _, col := range r.cols {
+		if col.insert {
+			cols = append(cols, col.Column)
+		}
+	}
+	return cols
+}
+
+// Next prepares the next row for reading with Values. It returns false when
+// there are no more rows or an error occurred, see Err.
+func (r *Rows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	for !r.rows.Next() {
+		if r.pager == nil || r.pager.done() || r.rows.Err() != nil {
+			return false
+		}
+
+		// Fetch next page
+		r.rows.Close()
+		r.pager.rows = 0
+
+		st, args := r.pager.query()
+
+		logQuery(r.logger, st, args)
+
+		rows, err := r.q.Query(st, args...)
+		if err != nil {
+			r.err = fmt.Errorf("query rows: %w", err)
+			return false
+		}
+
+		r.rows = rows
+	}
+
+	if err := r.rows.Scan(r.dest...); err != nil {
+		r.err = err
+		return false
+	}
+
+	if r.pager != nil {
+		r.pager.fetched(r.cols)
+	}
+
+	r.count++
+
+	return true
+}
+
+// Values returns the values of the current row. NULL is returned as nil,
+// integers as int64, floating point numbers as float64, timestamps as
+// time.Time, UUIDs as uuid.UUID, bytea as []byte and arrays as slices. Other
+// types are returned as string.
+func (r *Rows) Values() []interface{} {
+	var values []interface{}
+	for _, col := range r.cols {
+		if col.insert {
+			values = append(values, col.goValue())
+		}
+	}
+	return values
+}
+
+// literals returns the values of the current row quoted as SQL literals.
+func (r *Rows) literals() []string {
+	var literals []string
+	for _, col := range r.cols {
+		if col.insert {
+			literals = append(literals, col.literal())
+		}
+	}
+	return literals
+}
+
+// Err returns the error, if any, encountered during iteration.
+func (r *Rows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+// Close closes the underlying result set.
+func (r *Rows) Close() error {
+	return r.rows.Close()
+}
@@ -0,0 +1,176 @@
+package pgdump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	// dollarQuote matches the opening tag of a dollar-quoted string.
+	dollarQuote = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+	// copyFromStdin matches COPY statements reading data following the
+	// statement, which are not supported.
+	copyFromStdin = regexp.MustCompile(`(?is)^COPY\s.*\sFROM\s+STDIN\b`)
+)
+
+// scriptStatement is a statement read from a SQL script.
+type scriptStatement struct {
+	// Line number of the first line of the statement
+	line int
+
+	// Statement text including terminating semicolon
+	text string
+}
+
+// String returns the statement as in the script.
+func (st *scriptStatement) String() string {
+	return st.text + "\n"
+}
+
+// scriptReader splits a SQL script into statements.
+type scriptReader struct {
+	r *bufio.Reader
+
+	// Number of lines read
+	line int
+
+	// Rest of last line after previous statement
+	pending string
+}
+
+func newScriptReader(r io.Reader) *scriptReader {
+	return &scriptReader{r: bufio.NewReader(r)}
+}
+
+// readLine returns the next line including newline, io.EOF at end.
+func (sr *scriptReader) readLine() (string, error) {
+	s, err := sr.r.ReadString('\n')
+	if s == "" && err != nil {
+		return "", err
+	}
+	sr.line++
+	return s, nil
+}
+
+// next returns the next statement, io.EOF at end. Statements end with a
+// semicolon outside quotes, identifiers, comments and dollar-quoted strings.
+func (sr *scriptReader) next() (*scriptStatement, error) {
+	var buf strings.Builder
+
+	// Offset and line number of first character of statement
+	start := -1
+	startLine := 0
+
+	// Current quote character, dollar quote tag and comment state
+	var quote byte
+	var escapes bool
+	var dollar string
+	var lineComment bool
+	var blockComments int
+
+	for {
+		line := sr.pending
+		lineNo := sr.line
+		sr.pending = ""
+
+		if line == "" {
+			var err error
+			if line, err = sr.readLine(); err == io.EOF {
+				if start >= 0 {
+					return nil, fmt.Errorf("line %d: unterminated statement", startLine)
+				}
+				return nil, io.EOF
+			} else if err != nil {
+				return nil, err
+			}
+			lineNo = sr.line
+		}
+
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+
+			var next byte
+			if i+1 < len(line) {
+				next = line[i+1]
+			}
+
+			switch {
+			case lineComment:
+				if c == '\n' {
+					lineComment = false
+				}
+			case blockComments > 0:
+				if c == '*' && next == '/' {
+					blockComments--
+					i++
+				} else if c == '/' && next == '*' {
+					blockComments++
+					i++
+				}
+			case quote != 0:
+				if c == '\\' && escapes {
+					i++
+				} else if c == quote {
+					if next == quote {
+						i++
+					} else {
+						quote = 0
+					}
+				}
+			case dollar != "":
+				if strings.HasPrefix(line[i:], dollar) {
+					i += len(dollar) - 1
+					dollar = ""
+				}
+			case c == '-' && next == '-':
+				lineComment = true
+				i++
+			case c == '/' && next == '*':
+				blockComments++
+				i++
+			case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			default:
+				if start < 0 {
+					start = buf.Len() + i
+					startLine = lineNo
+				}
+
+				switch c {
+				case '\'':
+					quote = c
+					escapes = i > 0 && (line[i-1] == 'E' || line[i-1] == 'e')
+				case '"':
+					quote = c
+					escapes = false
+				case '$':
+					if tag := dollarQuote.FindString(line[i:]); tag != "" {
+						dollar = tag
+						i += len(tag) - 1
+					}
+				case ';':
+					buf.WriteString(line[:i+1])
+					if rest := line[i+1:]; strings.TrimSpace(rest) != "" {
+						sr.pending = rest
+					}
+
+					st := &scriptStatement{
+						line: startLine,
+						text: buf.String()[start:],
+					}
+
+					if copyFromStdin.MatchString(st.text) {
+						return nil, fmt.Errorf("line %d: COPY FROM STDIN is not supported", startLine)
+					}
+
+					return st, nil
+				}
+			}
+		}
+
+		buf.WriteString(line)
+	}
+}
@@ -0,0 +1,102 @@
+package pgdump
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// SequenceMode controls whether and how sequences owned by dumped columns are
+// updated after the dumped rows are inserted.
+type SequenceMode int
+
+const (
+	// NoSequences leaves sequences alone.
+	NoSequences SequenceMode = iota
+
+	// SourceSequences sets sequences to their value in the source database.
+	SourceSequences
+
+	// MaxSequences advances sequences to the max value of the column in the
+	// destination table, unless they are already past it.
+	MaxSequences
+)
+
+// ownedSequence is a sequence owned by a column e.g. by a serial or identity
+// column.
+type ownedSequence struct {
+	// Name of owning column
+	Column string
+
+	// Name of sequence as formatted by regclass
+	Name string
+}
+
+// getSequences fetches sequences owned by columns of table.
+func getSequences(q Querier, table string) ([]ownedSequence, error) {
+	rows, err := q.Query(`
+		SELECT a.attname, s.oid::regclass::text
+		FROM pg_depend d
+		JOIN pg_class s ON s.oid = d.objid AND s.relkind = 'S'
+		JOIN pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+		WHERE d.classid = 'pg_class'::regclass AND d.refclassid = 'pg_class'::regclass
+			AND d.refobjid = $1::regclass AND d.deptype IN ('a', 'i')
+		ORDER BY a.attnum
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var seqs []ownedSequence
+
+	for rows.Next() {
+		var seq ownedSequence
+		if err := rows.Scan(&seq.Column, &seq.Name); err != nil {
+			return nil, err
+		}
+		seqs = append(seqs, seq)
+	}
+
+	return seqs, rows.Err()
+}
+
+// getSequenceStatements returns statements updating the sequences in
+// destTable owned by the columns cols according to mode.
+func getSequenceStatements(q Querier, sourceTable, destTable string, cols []Column, mode SequenceMode) (string, error) {
+	seqs, err := getSequences(q, sourceTable)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+
+	for _, seq := range seqs {
+		if _, err := findKey(seq.Column, cols); err != nil {
+			// Column not dumped
+			continue
+		}
+
+		// The sequence may have a different name in destination database
+		name := "pg_get_serial_sequence(" + pq.QuoteLiteral(destTable) + ", " + pq.QuoteLiteral(seq.Column) + ")"
+
+		switch mode {
+		case SourceSequences:
+			var lastValue int64
+			var isCalled bool
+			if err := q.QueryRow(`SELECT last_value, is_called FROM `+seq.Name).Scan(&lastValue, &isCalled); err != nil {
+				return "", err
+			}
+			buf.WriteString("SELECT setval(" + name + ", " + strconv.FormatInt(lastValue, 10) + ", " + strconv.FormatBool(isCalled) + ");\n")
+		case MaxSequences:
+			buf.WriteString("SELECT setval(" + name + ", GREATEST((SELECT max(" + quoteColumn(seq.Column) + ") FROM " + destTable + "), pg_sequence_last_value(" + name + "::regclass)));\n")
+		default:
+			return "", fmt.Errorf("unknown sequence mode %d", mode)
+		}
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,30 @@
+package pgdump
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+)
+
+// BeginSnapshot begins a REPEATABLE READ READ ONLY transaction on db. All
+// queries in the transaction see the same snapshot of the database, so tables
+// dumped within it are consistent with each other. If snapshot is not empty,
+// it must be an id returned by pg_export_snapshot() in another transaction
+// that is still open, and the new transaction will see that snapshot instead.
+func BeginSnapshot(ctx context.Context, db *sql.DB, snapshot string) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot != "" {
+		if _, err := tx.ExecContext(ctx, `SET TRANSACTION SNAPSHOT `+pq.QuoteLiteral(snapshot)); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("import snapshot: %w", err)
+		}
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,166 @@
+package pgdump
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ManifestName is the name of the manifest file written by SplitWriter.
+const ManifestName = "manifest.json"
+
+// SplitFile describes a file written by SplitWriter.
+type SplitFile struct {
+	// File name relative to the directory
+	Name string `json:"name"`
+
+	// Number of statements in file, excluding Header and Footer
+	Statements int `json:"statements"`
+
+	// Size of file in bytes
+	Bytes int64 `json:"bytes"`
+}
+
+// SplitWriter writes statements to a series of files in a directory. A new
+// file is started when the current one would exceed MaxStatements statements
+// or MaxBytes bytes. Each file starts with Header and ends with Footer, e.g.
+// BEGIN and COMMIT, so it can be restored on its own. WriteStatement can be
+// used as the dumpFunc of Dump.
+type SplitWriter struct {
+	// Directory to write files to, created if missing
+	Dir string
+
+	// Pattern for file names, formatted with the file number starting at 1.
+	// Defaults to "%05d.sql".
+	Name string
+
+	// Max number of statements per file. Zero means no limit.
+	MaxStatements int
+
+	// Max size of each file in bytes. A file exceeds it only if a single
+	// statement does. Zero means no limit.
+	MaxBytes int64
+
+	// Text written at the start and end of each file
+	Header, Footer string
+
+	files []SplitFile
+	file  *os.File
+}
+
+// WriteStatement writes st to the current file, starting a new file first if
+// a limit would be exceeded.
+func (w *SplitWriter) WriteStatement(st string) error {
+	if w.file != nil && w.full(st) {
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+	}
+
+	if w.file == nil {
+		if err := w.openFile(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.write(st); err != nil {
+		return err
+	}
+
+	w.files[len(w.files)-1].Statements++
+
+	return nil
+}
+
+// full reports whether writing st would exceed a limit of the current file.
+func (w *SplitWriter) full(st string) bool {
+	cur := w.files[len(w.files)-1]
+
+	if cur.Statements == 0 {
+		return false
+	}
+
+	if w.MaxStatements > 0 && cur.Statements >= w.MaxStatements {
+		return true
+	}
+
+	return w.MaxBytes > 0 && cur.Bytes+int64(len(st)+len(w.Footer)) > w.MaxBytes
+}
+
+func (w *SplitWriter) openFile() error {
+	if err := os.MkdirAll(w.Dir, 0777); err != nil {
+		return err
+	}
+
+	pattern := w.Name
+	if pattern == "" {
+		pattern = "%05d.sql"
+	}
+
+	name := fmt.Sprintf(pattern, len(w.files)+1)
+
+	f, err := os.Create(filepath.Join(w.Dir, name))
+	if err != nil {
+		return err
+	}
+
+	w.file = f
+	w.files = append(w.files, SplitFile{Name: name})
+
+	return w.write(w.Header)
+}
+
+func (w *SplitWriter) write(s string) error {
+	n, err := w.file.WriteString(s)
+	w.files[len(w.files)-1].Bytes += int64(n)
+	return err
+}
+
+func (w *SplitWriter) closeFile() error {
+	err := w.write(w.Footer)
+
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	w.file = nil
+
+	return err
+}
+
+// Files returns the files written so far.
+func (w *SplitWriter) Files() []SplitFile {
+	return w.files
+}
+
+// Close finishes the current file and writes a manifest listing all files in
+// order to ManifestName in Dir.
+func (w *SplitWriter) Close() error {
+	if w.file != nil {
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(w.Dir, 0777); err != nil {
+		return err
+	}
+
+	manifest := struct {
+		Files []SplitFile `json:"files"`
+	}{
+		Files: w.files,
+	}
+
+	if manifest.Files == nil {
+		manifest.Files = []SplitFile{}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(w.Dir, ManifestName), append(data, '\n'), 0666)
+}
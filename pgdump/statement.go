@@ -2,6 +2,7 @@ package pgdump
 
 import (
 	"bytes"
+	"fmt"
 )
 
 // getQueryStatement returns SELECT statement to retrieve rows to dump.
@@ -26,58 +27,341 @@ func getQueryStatement(table string, cols []column) string {
 	return buf.String()
 }
 
-// getInsertStatement returns INSERT statement to output for row currently
-// loaded in to cols slice.
-func getInsertStatement(table string, cols []column, opts *Options) string {
+// StatementWriter renders the statement(s) to output for a dumped row.
+type StatementWriter interface {
+	// WriteStatement returns statement text for a row of table. The row has
+	// columns cols with values literals, quoted as SQL literals. The
+	// returned text should include a terminating semicolon and newline.
+	WriteStatement(table string, cols []Column, literals []string) (string, error)
+}
+
+// InsertWriter outputs a plain INSERT statement for each row.
+type InsertWriter struct {
+	// Append ON CONFLICT DO NOTHING clause.
+	NoConflict bool
+}
+
+// WriteStatement implements the StatementWriter interface.
+func (w InsertWriter) WriteStatement(table string, cols []Column, literals []string) (string, error) {
 	var buf bytes.Buffer
 
-	buf.WriteString("INSERT INTO " + table + " (")
+	writeInsert(&buf, table, cols, literals)
+
+	if w.NoConflict {
+		buf.WriteString(" ON CONFLICT DO NOTHING")
+	}
+
+	buf.WriteString(";\n")
+
+	return buf.String(), nil
+}
+
+// UpsertWriter outputs an INSERT statement with an ON CONFLICT clause for each
+// row. All columns except ConflictColumn end up in a DO UPDATE SET list unless
+// UpdateColumns is given.
+type UpsertWriter struct {
+	ConflictColumn string
+
+	// Columns to update on conflict. Defaults to all columns except
+	// ConflictColumn.
+	UpdateColumns []string
+}
+
+// update reports whether column name is updated on conflict.
+func (w UpsertWriter) update(name string) bool {
+	return isUpdated(name, w.ConflictColumn, w.UpdateColumns)
+}
+
+// isUpdated reports whether column name is in update, or, if update is empty,
+// any column except key.
+func isUpdated(name, key string, update []string) bool {
+	if len(update) == 0 {
+		return name != key
+	}
+	for _, col := range update {
+		if col == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteStatement implements the StatementWriter interface.
+func (w UpsertWriter) WriteStatement(table string, cols []Column, literals []string) (string, error) {
+	if w.ConflictColumn == "" {
+		return "", fmt.Errorf("no conflict column")
+	}
+
+	var buf bytes.Buffer
+
+	writeInsert(&buf, table, cols, literals)
+
+	buf.WriteString(" ON CONFLICT (" + w.ConflictColumn + ") ")
 	count := 0
 
+	for _, name := range w.UpdateColumns {
+		if _, err := findKey(name, cols); err != nil {
+			return "", fmt.Errorf("update column: %w", err)
+		}
+	}
+
 	for _, col := range cols {
-		if col.insert {
+		if w.update(col.Name) {
+			if count == 0 {
+				buf.WriteString("DO UPDATE SET ")
+			} else {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(quoteColumn(col.Name) + "=EXCLUDED." + quoteColumn(col.Name))
+			count++
+		}
+	}
+
+	if count == 0 {
+		buf.WriteString("DO NOTHING")
+	}
+
+	buf.WriteString(";\n")
+
+	return buf.String(), nil
+}
+
+// UpdateWriter outputs an UPDATE statement for each row, identifying the row
+// to update by KeyColumn.
+type UpdateWriter struct {
+	KeyColumn string
+
+	// Columns to update. Defaults to all columns except KeyColumn.
+	UpdateColumns []string
+}
+
+// WriteStatement implements the StatementWriter interface.
+func (w UpdateWriter) WriteStatement(table string, cols []Column, literals []string) (string, error) {
+	key, err := findKey(w.KeyColumn, cols)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range w.UpdateColumns {
+		if _, err := findKey(name, cols); err != nil {
+			return "", fmt.Errorf("update column: %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString("UPDATE " + table + " SET ")
+	count := 0
+
+	for i, col := range cols {
+		if i != key && isUpdated(col.Name, w.KeyColumn, w.UpdateColumns) {
 			if count > 0 {
 				buf.WriteString(", ")
 			}
-			buf.WriteString(quoteColumn(col.Name))
+			buf.WriteString(quoteColumn(col.Name) + "=" + literals[i])
 			count++
 		}
 	}
 
-	buf.WriteString(") VALUES (")
-	count = 0
+	if count == 0 {
+		return "", fmt.Errorf("no columns to update")
+	}
+
+	buf.WriteString(" WHERE " + quoteColumn(w.KeyColumn) + "=" + literals[key] + ";\n")
+
+	return buf.String(), nil
+}
+
+// DeleteWriter outputs a DELETE statement for each row, identifying the row
+// to delete by KeyColumn.
+type DeleteWriter struct {
+	KeyColumn string
+}
+
+// WriteStatement implements the StatementWriter interface.
+func (w DeleteWriter) WriteStatement(table string, cols []Column, literals []string) (string, error) {
+	key, err := findKey(w.KeyColumn, cols)
+	if err != nil {
+		return "", err
+	}
+
+	return "DELETE FROM " + table + " WHERE " + quoteColumn(w.KeyColumn) + "=" + literals[key] + ";\n", nil
+}
+
+// BatchStatementWriter is a StatementWriter that can combine several rows
+// into one statement. Dump passes up to BatchSize rows at a time to
+// WriteBatchStatement instead of calling WriteStatement for each row.
+type BatchStatementWriter interface {
+	StatementWriter
+
+	// BatchSize returns max number of rows per statement.
+	BatchSize() int
+
+	// WriteBatchStatement returns statement text for rows of table. Each
+	// element in rows holds the literals of one row, see WriteStatement.
+	WriteBatchStatement(table string, cols []Column, rows [][]string) (string, error)
+}
+
+// DefaultMergeBatchSize is the number of rows per MERGE statement if
+// MergeWriter.Rows is not set.
+const DefaultMergeBatchSize = 100
+
+// MergeWriter outputs MERGE statements, identifying rows by KeyColumn.
+// Matching rows are updated and other rows inserted. Requires PostgreSQL 15
+// or later.
+type MergeWriter struct {
+	KeyColumn string
+
+	// Columns to update in matching rows. Defaults to all columns except
+	// KeyColumn.
+	UpdateColumns []string
+
+	// Max number of rows per MERGE statement. Defaults to
+	// DefaultMergeBatchSize.
+	Rows int
+}
+
+// BatchSize implements the BatchStatementWriter interface.
+func (w MergeWriter) BatchSize() int {
+	if w.Rows <= 0 {
+		return DefaultMergeBatchSize
+	}
+	return w.Rows
+}
+
+// WriteStatement implements the StatementWriter interface.
+func (w MergeWriter) WriteStatement(table string, cols []Column, literals []string) (string, error) {
+	return w.WriteBatchStatement(table, cols, [][]string{literals})
+}
+
+// WriteBatchStatement implements the BatchStatementWriter interface.
+func (w MergeWriter) WriteBatchStatement(table string, cols []Column, rows [][]string) (string, error) {
+	if _, err := findKey(w.KeyColumn, cols); err != nil {
+		return "", err
+	}
+
+	for _, name := range w.UpdateColumns {
+		if _, err := findKey(name, cols); err != nil {
+			return "", fmt.Errorf("update column: %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString("MERGE INTO " + table + " AS t USING (VALUES ")
+
+	for i, literals := range rows {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("(")
+		for j, literal := range literals {
+			if j > 0 {
+				buf.WriteString(", ")
+			}
+			// Literals in a VALUES list would otherwise be resolved as text
+			buf.WriteString("CAST(" + literal + " AS " + cols[j].TypeName + ")")
+		}
+		buf.WriteString(")")
+	}
+
+	buf.WriteString(") AS s (")
+
+	for i, col := range cols {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(quoteColumn(col.Name))
+	}
+
+	key := quoteColumn(w.KeyColumn)
+	buf.WriteString(") ON t." + key + "=s." + key)
+	count := 0
 
 	for _, col := range cols {
-		if col.insert {
-			if count > 0 {
+		if col.Name != w.KeyColumn && isUpdated(col.Name, w.KeyColumn, w.UpdateColumns) {
+			if count == 0 {
+				buf.WriteString(" WHEN MATCHED THEN UPDATE SET ")
+			} else {
 				buf.WriteString(", ")
 			}
-			buf.WriteString(col.literal())
+			buf.WriteString(quoteColumn(col.Name) + "=s." + quoteColumn(col.Name))
 			count++
 		}
 	}
 
-	buf.WriteString(")")
+	buf.WriteString(" WHEN NOT MATCHED THEN INSERT (")
+
+	for i, col := range cols {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(quoteColumn(col.Name))
+	}
+
+	buf.WriteString(") VALUES (")
+
+	for i, col := range cols {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("s." + quoteColumn(col.Name))
+	}
+
+	buf.WriteString(");\n")
+
+	return buf.String(), nil
+}
+
+// getStatementWriter returns the StatementWriter to use for opts.
+func getStatementWriter(opts *Options) StatementWriter {
+	if opts.StatementWriter != nil {
+		return opts.StatementWriter
+	}
 
 	if opts.ConflictColumn != "" {
-		buf.WriteString(" ON CONFLICT (" + opts.ConflictColumn + ") DO UPDATE SET ")
-		count = 0
-		for _, col := range cols {
-			if col.update {
-				if count > 0 {
-					buf.WriteString(", ")
-				}
-				buf.WriteString(quoteColumn(col.Name) + "=EXCLUDED." + quoteColumn(col.Name))
-				count++
-			}
+		return UpsertWriter{ConflictColumn: opts.ConflictColumn, UpdateColumns: opts.UpdateColumns}
+	}
+
+	return InsertWriter{NoConflict: opts.NoConflict}
+}
+
+// writeInsert writes INSERT statement without ON CONFLICT clause.
+func writeInsert(buf *bytes.Buffer, table string, cols []Column, literals []string) {
+	buf.WriteString("INSERT INTO " + table + " (")
+
+	for i, col := range cols {
+		if i > 0 {
+			buf.WriteString(", ")
 		}
-	} else if opts.NoConflict {
-		buf.WriteString(" ON CONFLICT DO NOTHING")
+		buf.WriteString(quoteColumn(col.Name))
 	}
 
-	buf.WriteString(";\n")
+	buf.WriteString(") VALUES (")
 
-	return buf.String()
+	for i, literal := range literals {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(literal)
+	}
+
+	buf.WriteString(")")
+}
+
+// findKey returns index of key column in cols.
+func findKey(key string, cols []Column) (int, error) {
+	if key == "" {
+		return 0, fmt.Errorf("no key column")
+	}
+
+	for i, col := range cols {
+		if col.Name == key {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("key column %s not in dumped columns", key)
 }
 
 func quoteColumn(col string) string {
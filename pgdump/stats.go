@@ -0,0 +1,138 @@
+package pgdump
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stats describes a completed dump, see DumpStats.
+type Stats struct {
+	// Number of rows fetched
+	Rows int64
+
+	// Number of chunks passed to dumpFunc. A chunk is a single statement,
+	// possibly inserting a batch of rows, except that sequence and mirror
+	// statements are passed as one chunk each.
+	Statements int64
+
+	// Total length of statements passed to dumpFunc
+	Bytes int64
+
+	// Number of NULL values per dumped column
+	NullCounts map[string]int64
+
+	// Time spent querying and fetching rows
+	QueryDuration time.Duration
+
+	// Time spent in total
+	TotalDuration time.Duration
+
+	// Largest value of MaxKeyColumn, or ConflictColumn if not set, in the
+	// fetched rows as returned by Rows.Values. Nil if no column or no rows.
+	MaxKey interface{}
+
+	// Dumped columns and literals of the row being output, nil when not
+	// outputting a row
+	cols []Column
+	row  []string
+}
+
+// DumpStats is like Dump but also returns statistics about the dump. Stats
+// are returned also on error, covering the rows dumped before it.
+func DumpStats(dumpFunc func(string) error, q Querier, sourceTable string, opts *Options) (*Stats, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	stats := &Stats{NullCounts: make(map[string]int64)}
+	err := dump(dumpFunc, dumpFunc, q, sourceTable, opts, stats)
+
+	return stats, err
+}
+
+// wrap returns f updating Statements and Bytes.
+func (s *Stats) wrap(f func(string) error) func(string) error {
+	return func(st string) error {
+		s.Statements++
+		s.Bytes += int64(len(st))
+		return f(st)
+	}
+}
+
+// fetched records a fetched row with values quoted as SQL literals.
+func (s *Stats) fetched(cols []Column, literals []string) {
+	s.Rows++
+	s.cols = cols
+	s.row = literals
+
+	for i, literal := range literals {
+		if literal == "NULL" {
+			s.NullCounts[cols[i].Name]++
+		}
+	}
+}
+
+// key records a value v of the key column col.
+func (s *Stats) key(col Column, v interface{}) {
+	if v != nil && (s.MaxKey == nil || greater(col.Type, v, s.MaxKey)) {
+		s.MaxKey = v
+	}
+}
+
+// greater reports whether a > b for values of column type typ as returned by
+// Rows.Values. Other types, e.g. arrays, are never greater.
+func greater(typ string, a, b interface{}) bool {
+	switch a := a.(type) {
+	case int64:
+		return a > b.(int64)
+	case float64:
+		return a > b.(float64)
+	case string:
+		if typ == "numeric" || typ == "decimal" {
+			return greaterNumeric(a, b.(string))
+		}
+		return a > b.(string)
+	case bool:
+		return a && !b.(bool)
+	case time.Time:
+		return a.After(b.(time.Time))
+	case uuid.UUID:
+		bb := b.(uuid.UUID)
+		return bytes.Compare(a[:], bb[:]) > 0
+	}
+	return false
+}
+
+// greaterNumeric reports whether numeric a > b, ordered as by PostgreSQL
+// with NaN above Infinity.
+func greaterNumeric(a, b string) bool {
+	ra, rb := numericRank(a), numericRank(b)
+	if ra != rb || ra != 0 {
+		return ra > rb
+	}
+
+	x, okx := new(big.Rat).SetString(a)
+	y, oky := new(big.Rat).SetString(b)
+	if !okx || !oky {
+		return a > b
+	}
+
+	return x.Cmp(y) > 0
+}
+
+// numericRank returns the order of the special numeric values relative to
+// finite ones, which are 0.
+func numericRank(v string) int {
+	switch v {
+	case "-Infinity":
+		return -1
+	case "Infinity":
+		return 1
+	case "NaN":
+		return 2
+	}
+	return 0
+}
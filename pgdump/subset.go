@@ -0,0 +1,198 @@
+package pgdump
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SubsetOptions controls which foreign keys DumpSubset follows.
+type SubsetOptions struct {
+	// Max number of foreign keys to follow from the root table to rows
+	// referencing it. Zero means no limit. Rows referenced by dumped rows are
+	// always included so the dump can be restored.
+	Depth int
+
+	// Tables to neither dump nor follow foreign keys through.
+	Exclude []string
+}
+
+// subsetPath is a chain of foreign keys from the root table.
+type subsetPath struct {
+	// Last table in chain
+	table string
+
+	// Condition selecting the rows of table reached through this chain
+	cond string
+
+	// Number of foreign keys followed to referencing rows
+	depth int
+
+	// Whether to follow foreign keys to referencing rows. Once a chain has
+	// gone to referenced rows, it only continues to rows they reference.
+	down bool
+
+	// Index of last foreign key followed, -1 for the root table
+	via int
+}
+
+// next returns path extended to table with condition cond, following foreign
+// key i.
+func (p subsetPath) next(table, cond string, down bool, i int) subsetPath {
+	next := subsetPath{
+		table: table,
+		cond:  cond,
+		depth: p.depth,
+		down:  down,
+		via:   i,
+	}
+	if down {
+		next.depth++
+	}
+	return next
+}
+
+// DumpSubset outputs statements for the rows of rootTable matching Query and,
+// following foreign keys, the rows they reference and the rows referencing
+// them, recursively. Query must be empty or a WHERE clause. Tables are output
+// in foreign key order as by DumpTables. Self-referencing foreign keys and
+// cycles of foreign keys are followed until no more rows are found, which
+// takes a query per step. Rows of a self-referencing table are output in the
+// order the table is scanned, so restoring them may require the constraint to
+// be deferred.
+func DumpSubset(dumpFunc func(string) error, q Querier, rootTable string, opts *Options, subset *SubsetOptions) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if subset == nil {
+		subset = &SubsetOptions{}
+	}
+
+	filter, err := getFilter(opts)
+	if err != nil {
+		return fmt.Errorf("subset: %w", err)
+	}
+
+	if filter == "" {
+		filter = "TRUE"
+	} else {
+		filter = "(" + filter + ")"
+	}
+
+	root, err := resolveTable(q, rootTable)
+	if err != nil {
+		return fmt.Errorf("resolve table %s: %w", rootTable, err)
+	}
+
+	excluded := make(map[string]bool)
+	for _, table := range subset.Exclude {
+		name, err := resolveTable(q, table)
+		if err != nil {
+			return fmt.Errorf("resolve table %s: %w", table, err)
+		}
+		excluded[name] = true
+	}
+
+	fks, err := getForeignKeys(q)
+	if err != nil {
+		return fmt.Errorf("get foreign keys: %w", err)
+	}
+
+	var tables []string
+	conds := make(map[string][]string)
+
+	// Conditions of chains following foreign keys to referencing rows, which
+	// also follow foreign keys to referenced rows
+	downConds := make(map[string][]string)
+
+	visited := make(map[string]bool)
+	queue := []subsetPath{{table: root, cond: filter, down: true, via: -1}}
+
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+
+		// The same rows may be reached through different chains
+		visit := fmt.Sprintf("%s %t %s", path.table, path.down, path.cond)
+		if visited[visit] {
+			continue
+		}
+		visited[visit] = true
+
+		// Stop when the chain reaches no rows that were not already followed
+		// in the same direction
+		known := downConds[path.table]
+		if !path.down {
+			known = conds[path.table]
+		}
+
+		found, err := hasNewRows(q, path, known, opts.QueryArgs)
+		if err != nil {
+			return fmt.Errorf("subset %s: %w", path.table, err)
+		} else if !found && path.via >= 0 {
+			continue
+		}
+
+		if _, ok := conds[path.table]; !ok {
+			tables = append(tables, path.table)
+		}
+		conds[path.table] = append(conds[path.table], path.cond)
+		if path.down {
+			downConds[path.table] = append(downConds[path.table], path.cond)
+		}
+
+		for i, fk := range fks {
+			if fk.Table == path.table && !excluded[fk.RefTable] && !(path.down && path.via == i) {
+				// Rows referenced by this table, unless they are the rows we
+				// came from
+				cond := getSubsetCondition(fk.RefColumns, fk.Columns, path)
+				queue = append(queue, path.next(fk.RefTable, cond, false, i))
+			}
+
+			if fk.RefTable == path.table && path.down && (subset.Depth == 0 || path.depth < subset.Depth) && !excluded[fk.Table] {
+				// Rows referencing this table
+				cond := getSubsetCondition(fk.Columns, fk.RefColumns, path)
+				queue = append(queue, path.next(fk.Table, cond, true, i))
+			}
+		}
+	}
+
+	queries := make(map[string]string)
+	for table, cond := range conds {
+		queries[table] = "WHERE " + strings.Join(cond, " OR ")
+	}
+
+	getLogger(opts).Info("Subset spans tables", "table", root, "tables", strings.Join(tables, ", "))
+
+	return dumpTables(dumpFunc, q, tables, opts, queries)
+}
+
+// hasNewRows reports whether path selects rows not selected by any of known.
+func hasNewRows(q Querier, path subsetPath, known []string, args []interface{}) (bool, error) {
+	st := "SELECT EXISTS (SELECT 1 FROM " + path.table + " WHERE " + path.cond
+	if len(known) > 0 {
+		// Conditions are NULL rather than false for keys not found when the
+		// keys followed include NULL
+		st += " AND NOT coalesce(" + strings.Join(known, " OR ") + ", false)"
+	}
+	st += ")"
+
+	var found bool
+	err := q.QueryRow(st, args...).Scan(&found)
+
+	return found, err
+}
+
+// getSubsetCondition returns condition selecting rows whose cols match
+// pathCols of the rows selected by path.
+func getSubsetCondition(cols, pathCols []string, path subsetPath) string {
+	return "(" + quoteColumns(cols) + ") IN (SELECT " + quoteColumns(pathCols) + " FROM " + path.table + " WHERE " + path.cond + ")"
+}
+
+func quoteColumns(cols []string) string {
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = quoteColumn(col)
+	}
+	return strings.Join(quoted, ", ")
+}
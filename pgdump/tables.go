@@ -0,0 +1,288 @@
+package pgdump
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// foreignKey describes a foreign key constraint.
+type foreignKey struct {
+	// Name of constraint
+	Name string
+
+	// Referencing table and columns
+	Table   string
+	Columns []string
+
+	// Referenced table and columns
+	RefTable   string
+	RefColumns []string
+
+	// Whether constraint is deferrable
+	Deferrable bool
+}
+
+// CycleError is returned when tables can't be ordered because of cyclic
+// foreign keys.
+type CycleError struct {
+	// Tables that are part of or depend on a cycle.
+	Tables []string
+}
+
+func (e *CycleError) Error() string {
+	return "cyclic foreign keys between tables " + strings.Join(e.Tables, ", ")
+}
+
+// getForeignKeys fetches all foreign keys from database. Table names are
+// formatted as by regclass, i.e. schema-qualified when not in search_path.
+func getForeignKeys(q Querier) ([]foreignKey, error) {
+	rows, err := q.Query(`
+		SELECT c.conname, c.conrelid::regclass::text, c.confrelid::regclass::text,
+			ARRAY(
+				SELECT a.attname::text
+				FROM unnest(c.conkey) WITH ORDINALITY AS k(attnum, i)
+				JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+				ORDER BY k.i
+			),
+			ARRAY(
+				SELECT a.attname::text
+				FROM unnest(c.confkey) WITH ORDINALITY AS k(attnum, i)
+				JOIN pg_attribute a ON a.attrelid = c.confrelid AND a.attnum = k.attnum
+				ORDER BY k.i
+			),
+			c.condeferrable
+		FROM pg_constraint c
+		WHERE c.contype = 'f'
+		ORDER BY c.conrelid::regclass::text, c.conname
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var fks []foreignKey
+
+	for rows.Next() {
+		var fk foreignKey
+		if err := rows.Scan(&fk.Name, &fk.Table, &fk.RefTable, (*pq.StringArray)(&fk.Columns), (*pq.StringArray)(&fk.RefColumns), &fk.Deferrable); err != nil {
+			return nil, err
+		}
+		fks = append(fks, fk)
+	}
+
+	return fks, rows.Err()
+}
+
+// resolveTable returns the name of table as formatted by regclass.
+func resolveTable(q Querier, table string) (string, error) {
+	var name string
+	if err := q.QueryRow(`SELECT $1::regclass::text`, table).Scan(&name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// SchemaTables returns the names of all tables in schema.
+func SchemaTables(q Querier, schema string) ([]string, error) {
+	rows, err := q.Query(`
+		SELECT c.oid::regclass::text
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relkind IN ('r', 'p') AND NOT c.relispartition
+		ORDER BY c.relname
+	`, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tables []string
+
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no tables in schema %s", schema)
+	}
+
+	return tables, nil
+}
+
+// SortTables orders tables so that tables referenced by foreign keys come
+// before the tables referencing them. Otherwise the given order is kept.
+// Returned table names are formatted as by regclass. A *CycleError is returned
+// if tables have cyclic foreign keys.
+func SortTables(q Querier, tables []string) ([]string, error) {
+	sorted, _, err := sortTables(q, tables, false)
+	return sorted, err
+}
+
+// sortTables implements SortTables. If deferrable is true, deferrable foreign
+// keys are ignored when needed to break cycles and deferred is set to true if
+// any was.
+func sortTables(q Querier, tables []string, deferrable bool) (sorted []string, deferred bool, err error) {
+	var names []string
+	included := make(map[string]bool)
+
+	for _, table := range tables {
+		name, err := resolveTable(q, table)
+		if err != nil {
+			return nil, false, fmt.Errorf("resolve table %s: %w", table, err)
+		}
+		if !included[name] {
+			names = append(names, name)
+			included[name] = true
+		}
+	}
+
+	fks, err := getForeignKeys(q)
+	if err != nil {
+		return nil, false, fmt.Errorf("get foreign keys: %w", err)
+	}
+
+	// Foreign keys between requested tables, self-references can't be
+	// ordered and are ignored
+	var deps []foreignKey
+	for _, fk := range fks {
+		if fk.Table != fk.RefTable && included[fk.Table] && included[fk.RefTable] {
+			deps = append(deps, fk)
+		}
+	}
+
+	done := make(map[string]bool)
+
+	for len(sorted) < len(names) {
+		progress := false
+
+		for _, name := range names {
+			if done[name] || !ready(name, deps, done) {
+				continue
+			}
+			sorted = append(sorted, name)
+			done[name] = true
+			progress = true
+			break
+		}
+
+		if progress {
+			continue
+		}
+
+		// Remaining tables depend on a cycle
+		var remaining []string
+		for _, name := range names {
+			if !done[name] {
+				remaining = append(remaining, name)
+			}
+		}
+
+		if !deferrable {
+			return nil, false, &CycleError{Tables: remaining}
+		}
+
+		// Drop deferrable foreign keys and try again
+		var kept []foreignKey
+		for _, fk := range deps {
+			if fk.Deferrable && !done[fk.Table] {
+				deferred = true
+			} else {
+				kept = append(kept, fk)
+			}
+		}
+
+		if len(kept) == len(deps) {
+			return nil, false, &CycleError{Tables: remaining}
+		}
+
+		deps = kept
+	}
+
+	return sorted, deferred, nil
+}
+
+// ready reports whether all tables referenced by table are done.
+func ready(table string, deps []foreignKey, done map[string]bool) bool {
+	for _, fk := range deps {
+		if fk.Table == table && !done[fk.RefTable] {
+			return false
+		}
+	}
+	return true
+}
+
+// DumpTables outputs statements for all rows in several tables. Tables are
+// ordered with SortTables so referenced rows are inserted before the rows
+// referencing them. Options apply to every table except that InsertTable must
+// be empty. In mirror mode, DELETE statements are output after all INSERT
+// statements in reverse table order. Use a transaction from BeginSnapshot as q
+// for all tables to see the same snapshot.
+func DumpTables(dumpFunc func(string) error, q Querier, tables []string, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	return dumpTables(dumpFunc, q, tables, opts, nil)
+}
+
+// dumpTables implements DumpTables. If queries is not nil, it holds the Query
+// to use for each table.
+func dumpTables(dumpFunc func(string) error, q Querier, tables []string, opts *Options, queries map[string]string) error {
+	if opts.InsertTable != "" {
+		return fmt.Errorf("cannot use insert table when dumping several tables")
+	}
+
+	sorted, deferred, err := sortTables(q, tables, opts.DeferConstraints)
+	if err != nil {
+		return fmt.Errorf("sort tables: %w", err)
+	}
+
+	getLogger(opts).Info("Dumping tables", "tables", strings.Join(sorted, ", "))
+
+	if deferred {
+		if err := dumpFunc("SET CONSTRAINTS ALL DEFERRED;\n"); err != nil {
+			return err
+		}
+	}
+
+	var mirrors []string
+
+	mirrorFunc := func(st string) error {
+		mirrors = append(mirrors, st)
+		return nil
+	}
+
+	for _, table := range sorted {
+		tableOpts := opts
+		if queries != nil {
+			copied := *opts
+			copied.Query = queries[table]
+			tableOpts = &copied
+		}
+
+		stats := &Stats{NullCounts: make(map[string]int64)}
+		if err := dump(dumpFunc, mirrorFunc, q, table, tableOpts, stats); err != nil {
+			return fmt.Errorf("dump table %s: %w", table, err)
+		}
+	}
+
+	for i := len(mirrors) - 1; i >= 0; i-- {
+		if err := dumpFunc(mirrors[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}